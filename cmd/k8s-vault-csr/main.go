@@ -10,6 +10,8 @@ import (
 	"github.com/spf13/cobra/doc"
 	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/cmd/bootstrap"
 	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/cmd/controller"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/cmd/credential"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/cmd/kubeconfig"
 	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/util"
 	"k8s.io/apiserver/pkg/util/logs"
 )
@@ -64,7 +66,7 @@ var versionCmd = &cobra.Command{
 func init() {
 	logs.InitLogs()
 	rootCmd.Version = Version
-	rootCmd.AddCommand(bootstrap.Cmd, controller.Cmd, docsCmd, versionCmd)
+	rootCmd.AddCommand(bootstrap.Cmd, controller.Cmd, credential.Cmd, kubeconfig.Cmd, docsCmd, versionCmd)
 
 	// Setup glog
 	rootCmd.PersistentFlags().AddGoFlagSet(flag.CommandLine)
@@ -1,25 +1,56 @@
 package bootstrap
 
 import (
+	"bytes"
+	"io/ioutil"
+	"time"
+
 	"github.com/golang/glog"
 	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/controller/certificate/bootstrap"
 	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/util"
 	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/vault/token"
 	"k8s.io/client-go/tools/clientcmd"
+)
 
-	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+// Bootstrap modes
+const (
+	ModeIssue        = bootstrap.ModeIssue
+	ModeSign         = bootstrap.ModeSign
+	ModeSignVerbatim = bootstrap.ModeSignVerbatim
 )
 
 var (
 	// Bootstrap flags
+	mode      string
 	nodeName  string
 	groupName string
 
+	// Reuse flags
+	skipIfValidFor time.Duration
+	reuseCAPath    string
+
+	// Watch flags
+	watch             bool
+	renewFraction     float64
+	retryInterval     time.Duration
+	postRotateCommand string
+
+	// Key flags
+	keyConfig bootstrap.KeyConfig
+
 	// Vault generic flags
-	vaultAddr string
-	vaultAuth token.AuthProvider
+	vaultAddr   string
+	vaultAuth   token.AuthProvider
+	revokeToken bool
+
+	// Vault token cache flags
+	tokenCacheFile    string
+	tokenCacheKeyFile string
+	tokenCacheMinTTL  time.Duration
 
 	// Vault PKI flags
 	signVerbatim bool
@@ -28,9 +59,15 @@ var (
 	pkiTTL       string
 
 	// Kubeconfig flags
-	masterAddr string
-	insecure   bool
-	kubeconfig string
+	kubeconfig util.KubeconfigFlags
+
+	// Output file flags
+	certPath    string
+	keyPath     string
+	caPath      string
+	pemPath     string
+	outputOwner string
+	fileOwner   = util.NoFileOwner
 )
 
 var Cmd = &cobra.Command{
@@ -39,85 +76,331 @@ var Cmd = &cobra.Command{
 	Args:  cobra.NoArgs,
 	Long: `Create certificates with the "system:bootstrappers" group.
 
-  By default the role should be pre-configured in vault in such a way that it has 
-  "O=system:bootstrappers" and can be used as a client cert. This tool then needs 
-  permissions in vault to issue a cert with that role.
+  The certificate can be created in one of three modes:
+
+  issue (default): the role should be pre-configured in vault in such a way
+  that it has "O=system:bootstrappers" and can be used as a client cert. Vault
+  generates the private key, and this tool needs permissions in vault to issue
+  a cert with that role.
+
+  sign: the private key is generated locally and a CSR is signed using the
+  role, so the key never leaves the node. The role is configured the same way
+  as for issue, and this tool needs permissions in vault to sign with it.
 
-  Alternatively this tool can use the sign-verbatim endpoint, but it is 
-  discoraged as it requires giving access to the sign-verbatim endpoint to this 
-  tool, which is a lot of power.
+  sign-verbatim: the private key is generated locally and a CSR with the
+  group from --group-name is signed verbatim. This is discoraged as it requires
+  giving access to the sign-verbatim endpoint to this tool, which is a lot of
+  power.
 
-  Complete documentation of the RBAC required to have the generated certs work 
+  With --watch the command keeps running, keeping the vault token alive and
+  re-issuing the certificate once --watch-renew-fraction of its lifetime has
+  passed. This stops the node being stranded if the bootstrap certificate
+  expires before kubelet has rotated its own certificate.
+
+  Complete documentation of the RBAC required to have the generated certs work
   can be found here:
   https://kubernetes.io/docs/reference/command-line-tools-reference/kubelet-tls-bootstrapping/`,
 	Run: func(cmd *cobra.Command, args []string) {
-		client, err := api.NewClient(&api.Config{
-			Address:    vaultAddr,
-			MaxRetries: 10,
-		})
+		if err := validate(cmd.Flags()); err != nil {
+			glog.Exitf("invalid flags: %s", err)
+		}
+
+		if err := run(); err != nil {
+			glog.Exit(err)
+		}
+	},
+}
+
+// validate checks the flags required by the selected mode are set
+func validate(fs *pflag.FlagSet) error {
+	if signVerbatim {
+		if fs.Changed("mode") && mode != ModeSignVerbatim {
+			return errors.Errorf("--vault-pki-sign-verbatim conflicts with --mode=%s", mode)
+		}
+
+		mode = ModeSignVerbatim
+	}
+
+	switch mode {
+	case ModeIssue, ModeSign:
+		if pkiRole == "" {
+			return errors.Errorf("--vault-pki-role is required with --mode=%s", mode)
+		}
+
+		if fs.Changed("group-name") {
+			return errors.Errorf("--group-name can only be used with --mode=%s, the group is set by the vault role", ModeSignVerbatim)
+		}
+	case ModeSignVerbatim:
+		if groupName == "" {
+			return errors.Errorf("--group-name is required with --mode=%s", mode)
+		}
+	default:
+		return errors.Errorf("unknown mode %q, must be one of %s|%s|%s", mode, ModeIssue, ModeSign, ModeSignVerbatim)
+	}
+
+	if err := detectNode(); err != nil {
+		return err
+	}
+
+	if nodeName == "" {
+		return errors.New("--node-name or --node-name-source is required")
+	}
+
+	if mode == ModeIssue && keyConfig != (bootstrap.KeyConfig{}) {
+		return errors.Errorf("--key-algorithm and --key-size cannot be used with --mode=%s, the key type and size are set by the vault role", mode)
+	}
+
+	if _, err := keyConfig.Complete(); err != nil {
+		return errors.Wrap(err, "invalid --key-algorithm or --key-size")
+	}
+
+	if kubeconfig.Path == "" && certPath == "" && keyPath == "" && caPath == "" && pemPath == "" && secretName == "" {
+		return errors.New("at least one of --output-kubeconfig-path, --output-cert-path, --output-key-path, --output-ca-path, --output-pem-path or --output-secret-name is required")
+	}
+
+	if err := validateSecret(); err != nil {
+		return err
+	}
+
+	if kubeconfig.Names.Cluster == "" || kubeconfig.Names.User == "" || kubeconfig.Names.Context == "" {
+		return errors.New("the kubeconfig cluster, user and context names cannot be empty")
+	}
+
+	if (certPath == "") != (keyPath == "") {
+		return errors.New("--output-cert-path and --output-key-path must be used together")
+	}
+
+	owner, err := util.ParseFileOwner(outputOwner)
+	if err != nil {
+		return errors.Wrap(err, "invalid --output-owner")
+	}
+
+	fileOwner = owner
+
+	if skipIfValidFor > 0 && kubeconfig.Path == "" {
+		return errors.New("--skip-if-valid-for requires --output-kubeconfig-path")
+	}
+
+	// the existing certificate is verified without contacting vault
+	if skipIfValidFor > 0 && reuseCAPath == "" && caPath == "" {
+		return errors.New("--skip-if-valid-for requires --skip-if-valid-for-ca-path or --output-ca-path to verify the existing certificate")
+	}
+
+	// the current certificate is read back from the output to know when to
+	// re-issue it, the output secret is not read back
+	if watch && certPath == "" && pemPath == "" && kubeconfig.Path == "" {
+		return errors.New("--watch requires --output-cert-path, --output-pem-path or --output-kubeconfig-path, an output secret alone cannot be watched")
+	}
+
+	if renewFraction <= 0 || renewFraction >= 1 {
+		return errors.New("--watch-renew-fraction must be between 0 and 1")
+	}
+
+	if tokenCacheFile != "" && revokeToken {
+		return errors.New("--vault-token-cache-file cannot be used with --vault-revoke-token")
+	}
+
+	return nil
+}
+
+func run() error {
+	if skipIfValidFor > 0 && !watch {
+		if err := checkExistingKubeconfig(kubeconfig.Path, skipIfValidFor); err != nil {
+			glog.Infof("existing kubeconfig cannot be reused: %s", err)
+		} else {
+			glog.Infof("existing kubeconfig is valid for more than %s, skipping", skipIfValidFor)
+			return nil
+		}
+	}
 
+	client, renewer, err := login()
+	if err != nil {
+		return err
+	}
+
+	if watch {
+		return runWatch(client, renewer)
+	}
+
+	err = issue(client)
+
+	// the token is no longer needed once the certificate is issued
+	if revokeToken {
+		if err := renewer.RevokeSelf(); err != nil {
+			glog.Warningf("revoke vault token: %s", err)
+		}
+	}
+
+	return err
+}
+
+// login creates a vault client with a valid token, reusing the cached token
+// when a cache file is configured
+func login() (*api.Client, *token.Renewer, error) {
+	client, err := api.NewClient(&api.Config{
+		Address:    vaultAddr,
+		MaxRetries: 10,
+	})
+
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create vault client")
+	}
+
+	cache := token.TokenCache{File: tokenCacheFile}
+
+	if tokenCacheKeyFile != "" {
+		key, err := ioutil.ReadFile(tokenCacheKeyFile)
 		if err != nil {
-			glog.Exitf("create vault client: %s", err)
+			return nil, nil, errors.Wrap(err, "read vault token cache key")
 		}
 
-		err = token.NewRenewer(client, vaultAuth).RunOnce()
+		// as with the token and secret id files, a trailing newline is not
+		// part of the key
+		cache.Key = bytes.TrimSpace(key)
+		if len(cache.Key) == 0 {
+			return nil, nil, errors.New("vault token cache key file is empty")
+		}
+	}
 
+	cached := false
+	if tokenCacheFile != "" {
+		cached, err = cache.Restore(client, tokenCacheMinTTL)
 		if err != nil {
-			glog.Exitf("renew vault token: %s", err)
+			glog.Warningf("restore cached vault token: %s", err)
 		}
+	}
 
-		var key, cert, ca []byte
+	renewer := token.NewRenewer(client, vaultAuth)
+	err = renewer.RunOnce()
 
-		if signVerbatim {
-			key, cert, ca, err = bootstrap.CreateBootstrapCertWithSignVerbatim(client, pkiMount, pkiRole, pkiTTL, nodeName, groupName)
-		} else {
-			key, cert, ca, err = bootstrap.CreateBootstrapCertWithIssue(client, pkiMount, pkiRole, pkiTTL, nodeName)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "renew vault token")
+	}
+
+	if tokenCacheFile != "" && !cached {
+		if err := cache.Save(client.Token()); err != nil {
+			glog.Warningf("cache vault token: %s", err)
+		}
+	}
+
+	return client, renewer, nil
+}
+
+// issue creates a bootstrap certificate using the selected mode and writes it
+// to the configured outputs
+func issue(client *api.Client) error {
+	// the group is ignored unless signing verbatim, as it is set by the role
+	identity := bootstrap.NodeIdentity(nodeName, groupName)
+
+	key, cert, ca, err := bootstrap.CreateCert(client, mode, pkiMount, pkiRole, pkiTTL, identity, keyConfig)
+	if err != nil {
+		return errors.Wrap(err, "generate bootstrap certificate")
+	}
+
+	return writeOutput(key, cert, ca)
+}
+
+// writeOutput writes the bootstrap credentials to each of the configured
+// output paths and the output secret, files containing the private key are
+// only readable by the owner
+func writeOutput(key, cert, ca []byte) error {
+	if certPath != "" {
+		if err := util.WriteFileAtomic(certPath, cert, 0644, fileOwner); err != nil {
+			return errors.Wrap(err, "write certificate to disk")
 		}
+	}
 
+	if keyPath != "" {
+		if err := util.WriteFileAtomic(keyPath, key, 0600, fileOwner); err != nil {
+			return errors.Wrap(err, "write key to disk")
+		}
+	}
+
+	if caPath != "" {
+		if err := util.WriteFileAtomic(caPath, ca, 0644, fileOwner); err != nil {
+			return errors.Wrap(err, "write ca to disk")
+		}
+	}
+
+	if pemPath != "" {
+		pem := bytes.Join([][]byte{cert, ca, key}, nil)
+		if err := util.WriteFileAtomic(pemPath, pem, 0600, fileOwner); err != nil {
+			return errors.Wrap(err, "write pem to disk")
+		}
+	}
+
+	kubeconfigData := bootstrap.NewKubeconfig(kubeconfig.Names, kubeconfig.MasterAddr, kubeconfig.Insecure, key, cert, ca)
+
+	if secretName != "" {
+		// the secret holds a standalone kubeconfig, it is never merged
+		data, err := clientcmd.Write(kubeconfigData)
 		if err != nil {
-			glog.Exitf("generate bootstrap certificate: %s", err)
-		}
-
-		kubeconfigData := clientcmdapi.Config{
-			// Define a cluster stanza based on the bootstrap kubeconfig.
-			Clusters: map[string]*clientcmdapi.Cluster{"default-cluster": {
-				Server:                   masterAddr,
-				InsecureSkipTLSVerify:    insecure,
-				CertificateAuthorityData: ca,
-			}},
-			// Define auth based on the obtained client cert.
-			AuthInfos: map[string]*clientcmdapi.AuthInfo{"default-auth": {
-				ClientCertificateData: cert,
-				ClientKeyData:         key,
-			}},
-			// Define a context that connects the auth info and cluster, and set it as the default
-			Contexts: map[string]*clientcmdapi.Context{"default-context": {
-				Cluster:   "default-cluster",
-				AuthInfo:  "default-auth",
-				Namespace: "default",
-			}},
-			CurrentContext: "default-context",
-		}
-
-		// Marshal to disk
-		err = clientcmd.WriteToFile(kubeconfigData, kubeconfig)
+			return errors.Wrap(err, "marshal kubeconfig")
+		}
+
+		if err := writeSecret(key, cert, ca, data); err != nil {
+			return errors.Wrap(err, "write secret")
+		}
+	}
+
+	if kubeconfig.Path == "" {
+		return nil
+	}
+
+	if kubeconfig.Merge {
+		merged, err := bootstrap.MergeKubeconfig(kubeconfig.Path, kubeconfigData, kubeconfig.SetCurrentContext)
 		if err != nil {
-			glog.Exitf("write kubeconfig to disk: %s", err)
+			return err
 		}
-	},
+
+		kubeconfigData = *merged
+	}
+
+	// Marshal to disk
+	data, err := clientcmd.Write(kubeconfigData)
+	if err != nil {
+		return errors.Wrap(err, "marshal kubeconfig")
+	}
+
+	err = util.WriteFileAtomic(kubeconfig.Path, data, 0600, fileOwner)
+	return errors.Wrap(err, "write kubeconfig to disk")
 }
 
 func init() {
+	Cmd.Flags().StringVar(&mode, "mode", ModeIssue, "how to create the bootstrap certificate (issue|sign|sign-verbatim)")
 	Cmd.Flags().StringVar(&nodeName, "node-name", "", "node name to use in the bootstrap certificate")
-	Cmd.Flags().StringVar(&groupName, "group-name", "system:bootstrappers", "group name to use in the bootstrap certificate")
-	Cmd.Flags().StringVar(&vaultAddr, "vault-address", "", "vault server address")
-	Cmd.Flags().StringVar(&pkiMount, "vault-pki-mount", "pki", "specify the pki mount to use to generate certificates")
-	Cmd.Flags().StringVar(&pkiRole, "vault-pki-role", "", "specify role to use, only ttl is used from the role")
-	Cmd.Flags().StringVar(&pkiRole, "vault-pki-sign-verbatim", "", "use sign-verbatim to create the bootstrap certificate")
-	Cmd.Flags().StringVar(&pkiTTL, "vault-pki-ttl", "1h", "ttl of the bootstrap certificate")
-	Cmd.Flags().StringVar(&masterAddr, "output-kubeconfig-master-url", "", "url of the apiserver")
-	Cmd.Flags().BoolVar(&insecure, "output-kubeconfig-insecure", false, "allow insecure certificates for the apiserver")
-	Cmd.Flags().StringVar(&kubeconfig, "output-kubeconfig-path", "", "path to write kubeconfig to")
-
-	util.FlagAuthProvider(&vaultAuth, Cmd.Flags())
+	Cmd.Flags().StringVar(&groupName, "group-name", "system:bootstrappers", "group name to use in the bootstrap certificate, only used with --mode=sign-verbatim")
+	Cmd.Flags().DurationVar(&skipIfValidFor, "skip-if-valid-for", 0, "skip issuing when the certificate in the existing kubeconfig is valid for at least this long, disabled if zero")
+	Cmd.Flags().StringVar(&reuseCAPath, "skip-if-valid-for-ca-path", "", "path to the PEM encoded ca of the vault pki mount used to verify the existing kubeconfig, defaults to --output-ca-path")
+	Cmd.Flags().BoolVar(&watch, "watch", false, "keep running and re-issue the certificate before it expires")
+	Cmd.Flags().Float64Var(&renewFraction, "watch-renew-fraction", 0.7, "fraction of the certificate lifetime after which it is re-issued")
+	Cmd.Flags().DurationVar(&retryInterval, "watch-retry-interval", 30*time.Second, "time to wait before retrying a failed re-issue")
+	Cmd.Flags().StringVar(&postRotateCommand, "watch-post-rotate-command", "", "shell command to run after each rotation")
+	Cmd.Flags().BoolVar(&signVerbatim, "vault-pki-sign-verbatim", false, "use sign-verbatim to create the bootstrap certificate")
+	Cmd.Flags().StringVar(&certPath, "output-cert-path", "", "path to write the PEM encoded certificate to")
+	Cmd.Flags().StringVar(&keyPath, "output-key-path", "", "path to write the PEM encoded private key to")
+	Cmd.Flags().StringVar(&caPath, "output-ca-path", "", "path to write the PEM encoded ca bundle to")
+	Cmd.Flags().StringVar(&pemPath, "output-pem-path", "", "path to write the certificate, ca bundle and private key to as a single PEM file")
+
+	Cmd.Flags().MarkDeprecated("vault-pki-sign-verbatim", "use --mode=sign-verbatim instead")
+
+	util.FlagKubeconfig(&kubeconfig, Cmd.Flags())
+	flagNodeDetection(Cmd.Flags())
+	flagSecret(Cmd.Flags())
+	flagVault(Cmd.Flags(), &pkiTTL, "1h")
+}
+
+// flagVault creates the vault, key and ownership flags shared by bootstrap
+// and its subcommands. Each command has its own ttl variable, as the default
+// is set on the variable when the flag is created.
+func flagVault(fs *pflag.FlagSet, ttl *string, defaultTTL string) {
+	util.FlagKeyConfig(&keyConfig, fs)
+	util.FlagVault(&vaultAddr, &revokeToken, fs)
+	util.FlagPKI(&pkiMount, &pkiRole, ttl, defaultTTL, fs)
+	fs.StringVar(&tokenCacheFile, "vault-token-cache-file", "", "file to cache the vault token in so it can be reused by later runs")
+	fs.StringVar(&tokenCacheKeyFile, "vault-token-cache-key-file", "", "file containing a key used to encrypt the vault token cache")
+	fs.DurationVar(&tokenCacheMinTTL, "vault-token-cache-min-ttl", 10*time.Minute, "minimum ttl a cached vault token must have left to be reused")
+	fs.StringVar(&outputOwner, "output-owner", "", "numeric owner of the output files in the format uid[:gid]")
+
+	util.FlagAuthProvider(&vaultAuth, fs)
 }
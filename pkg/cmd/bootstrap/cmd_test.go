@@ -0,0 +1,342 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/builtin/logical/pki"
+	"github.com/hashicorp/vault/helper/logging"
+	"github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical/inmem"
+	"github.com/hashicorp/vault/vault"
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/cert"
+)
+
+// testVaultServer starts an in memory vault server with a pki mount and a
+// role called test that issues bootstrap certificates
+func testVaultServer(t *testing.T) (*api.Client, func()) {
+	logger := logging.NewVaultLogger(log.Trace)
+
+	phys, err := inmem.NewInmem(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, err := vault.NewCore(&vault.CoreConfig{
+		Physical: phys,
+		LogicalBackends: map[string]logical.Factory{
+			"pki": func(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
+				return pki.Factory(ctx, conf)
+			},
+		},
+		DisableMlock: true,
+	})
+
+	if err != nil {
+		t.Fatal("error initializing core: ", err)
+	}
+
+	init, err := core.Initialize(context.Background(), &vault.InitParams{
+		BarrierConfig: &vault.SealConfig{
+			SecretShares:    1,
+			SecretThreshold: 1,
+		},
+		RecoveryConfig: nil,
+	})
+
+	if err != nil {
+		t.Fatal("error initializing core: ", err)
+	}
+
+	if unsealed, err := core.Unseal(init.SecretShares[0]); err != nil {
+		t.Fatal("error unsealing core: ", err)
+	} else if !unsealed {
+		t.Fatal("vault shouldn't be sealed")
+	}
+
+	ln, addr := http.TestServer(nil, core)
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = addr
+	client, err := api.NewClient(clientConfig)
+
+	if err != nil {
+		ln.Close()
+		t.Fatal("error initializing HTTP client: ", err)
+	}
+
+	client.SetToken(init.RootToken)
+
+	// Setup vault mounts
+
+	err = client.Sys().Mount("pki", &api.MountInput{
+		Type: "pki",
+		Config: api.MountConfigInput{
+			MaxLeaseTTL: "87600h",
+		},
+	})
+
+	if err != nil {
+		ln.Close()
+		t.Fatal("error mounting pki: ", err)
+	}
+
+	_, err = client.Logical().Write("pki/root/generate/internal", map[string]interface{}{
+		"common_name": "Test Vault CA",
+		"ttl":         "87600h",
+	})
+
+	if err != nil {
+		ln.Close()
+		t.Fatal("error generating root ca: ", err)
+	}
+
+	_, err = client.Logical().Write("pki/roles/test", map[string]interface{}{
+		"ttl":               "7665h",
+		"allow_any_name":    true,
+		"enforce_hostnames": false,
+		"server_flag":       false,
+		"client_flag ":      false,
+		"key_usage":         []string{"DigitalSignature", "KeyEncipherment"},
+		"ext_key_usage":     []string{"ClientAuth"},
+		"organization":      []string{"system:bootstrappers"},
+		"key_type":          "ec",
+		"key_bits":          256,
+	})
+
+	if err != nil {
+		ln.Close()
+		t.Fatal("error generating test role: ", err)
+	}
+
+	return client, func() { ln.Close() }
+}
+
+// resetFlags resets the command flags to their defaults and sets the given
+// flags, returning the flag set so it can be validated
+func resetFlags(t *testing.T, flags map[string]string) *pflag.FlagSet {
+	Cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		f.Value.Set(f.DefValue)
+		f.Changed = false
+	})
+
+	vaultAuth = nil
+	secretLabelFlags = nil
+
+	for name, value := range flags {
+		if err := Cmd.Flags().Set(name, value); err != nil {
+			t.Fatalf("setting flag %s: %s", name, err)
+		}
+	}
+
+	return Cmd.Flags()
+}
+
+func TestValidate(t *testing.T) {
+	base := map[string]string{
+		"node-name":              "k-a-node-s36b",
+		"output-kubeconfig-path": "/tmp/kubeconfig",
+	}
+
+	cases := []struct {
+		name  string
+		flags map[string]string
+		valid bool
+	}{
+		{"issue", map[string]string{"vault-pki-role": "test"}, true},
+		{"issue without role", map[string]string{}, false},
+		{"sign", map[string]string{"mode": "sign", "vault-pki-role": "test"}, true},
+		{"sign with group", map[string]string{"mode": "sign", "vault-pki-role": "test", "group-name": "system:nodes"}, false},
+		{"sign-verbatim", map[string]string{"mode": "sign-verbatim", "group-name": "system:nodes"}, true},
+		{"sign-verbatim without group", map[string]string{"mode": "sign-verbatim", "group-name": ""}, false},
+		{"deprecated sign-verbatim", map[string]string{"vault-pki-sign-verbatim": "true"}, true},
+		{"deprecated sign-verbatim conflict", map[string]string{"vault-pki-sign-verbatim": "true", "mode": "issue"}, false},
+		{"unknown mode", map[string]string{"mode": "generate"}, false},
+		{"rsa key", map[string]string{"mode": "sign", "vault-pki-role": "test", "key-algorithm": "rsa", "key-size": "4096"}, true},
+		{"ecdsa key", map[string]string{"mode": "sign", "vault-pki-role": "test", "key-algorithm": "ecdsa", "key-size": "384"}, true},
+		{"bad key size", map[string]string{"mode": "sign", "vault-pki-role": "test", "key-algorithm": "rsa", "key-size": "384"}, false},
+		{"unknown key algorithm", map[string]string{"mode": "sign", "vault-pki-role": "test", "key-algorithm": "dsa"}, false},
+		{"issue with key", map[string]string{"vault-pki-role": "test", "key-algorithm": "rsa"}, false},
+		{"issue with key size", map[string]string{"vault-pki-role": "test", "key-size": "4096"}, false},
+		{"no output", map[string]string{"vault-pki-role": "test", "output-kubeconfig-path": ""}, false},
+		{"cert output", map[string]string{"vault-pki-role": "test", "output-kubeconfig-path": "", "output-cert-path": "/tmp/cert.pem", "output-key-path": "/tmp/key.pem"}, true},
+		{"cert output without key", map[string]string{"vault-pki-role": "test", "output-cert-path": "/tmp/cert.pem"}, false},
+		{"pem output", map[string]string{"vault-pki-role": "test", "output-kubeconfig-path": "", "output-pem-path": "/tmp/bundle.pem"}, true},
+		{"owner", map[string]string{"vault-pki-role": "test", "output-owner": "1000:1000"}, true},
+		{"bad owner", map[string]string{"vault-pki-role": "test", "output-owner": "kubelet"}, false},
+		{"no node name", map[string]string{"vault-pki-role": "test", "node-name": ""}, false},
+		{"detected node name", map[string]string{"vault-pki-role": "test", "node-name": "", "node-name-source": "hostname"}, true},
+		{"unknown node name source", map[string]string{"vault-pki-role": "test", "node-name": "", "node-name-source": "vsphere"}, false},
+		{"watch", map[string]string{"vault-pki-role": "test", "watch": "true", "watch-renew-fraction": "0.5"}, true},
+		{"secret output", map[string]string{"vault-pki-role": "test", "output-kubeconfig-path": "", "output-secret-name": "bootstrap"}, true},
+		{"tls secret output", map[string]string{"vault-pki-role": "test", "output-secret-name": "bootstrap", "output-secret-format": "tls"}, true},
+		{"unknown secret format", map[string]string{"vault-pki-role": "test", "output-secret-name": "bootstrap", "output-secret-format": "pem"}, false},
+		{"secret labels", map[string]string{"vault-pki-role": "test", "output-secret-name": "bootstrap", "output-secret-label": "app=kubelet,tier=node"}, true},
+		{"bad secret label", map[string]string{"vault-pki-role": "test", "output-secret-name": "bootstrap", "output-secret-label": "kubelet"}, false},
+		{"watch without certificate output", map[string]string{"vault-pki-role": "test", "watch": "true", "output-kubeconfig-path": "", "output-ca-path": "/tmp/ca.pem"}, false},
+		{"watch with secret output", map[string]string{"vault-pki-role": "test", "watch": "true", "output-kubeconfig-path": "", "output-secret-name": "bootstrap"}, false},
+		{"watch with pem output", map[string]string{"vault-pki-role": "test", "watch": "true", "output-kubeconfig-path": "", "output-pem-path": "/tmp/bundle.pem"}, true},
+		{"skip without ca", map[string]string{"vault-pki-role": "test", "skip-if-valid-for": "10m"}, false},
+		{"skip with output ca", map[string]string{"vault-pki-role": "test", "skip-if-valid-for": "10m", "output-ca-path": "/tmp/ca.pem"}, true},
+		{"skip with reuse ca", map[string]string{"vault-pki-role": "test", "skip-if-valid-for": "10m", "skip-if-valid-for-ca-path": "/tmp/ca.pem"}, true},
+		{"bad renew fraction", map[string]string{"vault-pki-role": "test", "watch": "true", "watch-renew-fraction": "1.5"}, false},
+	}
+
+	for _, c := range cases {
+		flags := map[string]string{}
+		for k, v := range base {
+			flags[k] = v
+		}
+		for k, v := range c.flags {
+			flags[k] = v
+		}
+
+		err := validate(resetFlags(t, flags))
+		if c.valid && err != nil {
+			t.Errorf("%s: expected flags to be valid but got: %s", c.name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: expected flags to be invalid", c.name)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	client, stop := testVaultServer(t)
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer os.Setenv(api.EnvVaultToken, os.Getenv(api.EnvVaultToken))
+	os.Setenv(api.EnvVaultToken, client.Token())
+
+	cases := []struct {
+		mode  string
+		flags map[string]string
+		group string
+	}{
+		{ModeIssue, map[string]string{"vault-pki-role": "test"}, "system:bootstrappers"},
+		{ModeSign, map[string]string{"vault-pki-role": "test"}, "system:bootstrappers"},
+		{ModeSignVerbatim, map[string]string{"group-name": "system:nodes"}, "system:nodes"},
+	}
+
+	for _, c := range cases {
+		path := filepath.Join(dir, c.mode)
+
+		flags := map[string]string{
+			"mode":                         c.mode,
+			"node-name":                    "k-a-node-s36b",
+			"vault-address":                client.Address(),
+			"output-kubeconfig-master-url": "https://apiserver:6443",
+			"output-kubeconfig-path":       path,
+			"output-cert-path":             path + ".crt",
+			"output-key-path":              path + ".key",
+			"output-ca-path":               path + ".ca",
+			"output-pem-path":              path + ".pem",
+		}
+
+		for k, v := range c.flags {
+			flags[k] = v
+		}
+
+		if err := validate(resetFlags(t, flags)); err != nil {
+			t.Fatalf("%s: invalid flags: %s", c.mode, err)
+		}
+
+		if err := run(); err != nil {
+			t.Errorf("%s: error running bootstrap: %s", c.mode, err)
+			continue
+		}
+
+		config, err := clientcmd.LoadFromFile(path)
+		if err != nil {
+			t.Errorf("%s: error loading kubeconfig: %s", c.mode, err)
+			continue
+		}
+
+		context := config.Contexts[config.CurrentContext]
+		if context == nil {
+			t.Errorf("%s: current context %q not found", c.mode, config.CurrentContext)
+			continue
+		}
+
+		cluster := config.Clusters[context.Cluster]
+		if cluster == nil || cluster.Server != "https://apiserver:6443" {
+			t.Errorf("%s: unexpected cluster %v", c.mode, cluster)
+			continue
+		}
+
+		if _, err := cert.ParseCertsPEM(cluster.CertificateAuthorityData); err != nil {
+			t.Errorf("%s: invalid ca data: %s", c.mode, err)
+		}
+
+		authInfo := config.AuthInfos[context.AuthInfo]
+		if authInfo == nil {
+			t.Errorf("%s: auth info %q not found", c.mode, context.AuthInfo)
+			continue
+		}
+
+		if _, err := cert.ParsePrivateKeyPEM(authInfo.ClientKeyData); err != nil {
+			t.Errorf("%s: invalid client key: %s", c.mode, err)
+		}
+
+		certs, err := cert.ParseCertsPEM(authInfo.ClientCertificateData)
+		if err != nil || len(certs) != 1 {
+			t.Errorf("%s: invalid client certificate: %v", c.mode, err)
+			continue
+		}
+
+		if certs[0].Subject.CommonName != "system:node:k-a-node-s36b" {
+			t.Errorf("%s: expected common name of 'system:node:k-a-node-s36b', but got: %v", c.mode, certs[0].Subject.CommonName)
+		}
+		if !reflect.DeepEqual(certs[0].Subject.Organization, []string{c.group}) {
+			t.Errorf("%s: expected organization to be [%s] but got: %v", c.mode, c.group, certs[0].Subject.Organization)
+		}
+
+		outputs := []struct {
+			path string
+			data []byte
+			perm os.FileMode
+		}{
+			{path, nil, 0600},
+			{path + ".crt", authInfo.ClientCertificateData, 0644},
+			{path + ".key", authInfo.ClientKeyData, 0600},
+			{path + ".ca", cluster.CertificateAuthorityData, 0644},
+			{path + ".pem", bytes.Join([][]byte{authInfo.ClientCertificateData, cluster.CertificateAuthorityData, authInfo.ClientKeyData}, nil), 0600},
+		}
+
+		for _, o := range outputs {
+			info, err := os.Stat(o.path)
+			if err != nil {
+				t.Errorf("%s: error reading %s: %s", c.mode, o.path, err)
+				continue
+			}
+
+			if info.Mode().Perm() != o.perm {
+				t.Errorf("%s: expected %s to have permissions %v but got %v", c.mode, o.path, o.perm, info.Mode().Perm())
+			}
+
+			if o.data == nil {
+				continue
+			}
+
+			if data, _ := ioutil.ReadFile(o.path); !bytes.Equal(data, o.data) {
+				t.Errorf("%s: unexpected contents of %s", c.mode, o.path)
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package bootstrap
+
+import (
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/node"
+)
+
+var (
+	// Node detection flags
+	nodeDetector node.Detector
+	nodeIdentity *node.Identity
+)
+
+// detectNode detects the node identity from the configured source and uses
+// it as the node name when one is not set explicitly
+func detectNode() error {
+	nodeIdentity = nil
+
+	if nodeDetector.Source == "" {
+		return nil
+	}
+
+	identity, err := nodeDetector.Detect()
+	if err != nil {
+		return errors.Wrapf(err, "detecting node identity from %s", nodeDetector.Source)
+	}
+
+	glog.Infof("detected node %s with dns names %v and ip addresses %v from %s", identity.Name, identity.DNSNames, identity.IPAddresses, nodeDetector.Source)
+
+	if nodeName == "" {
+		nodeName = identity.Name
+	}
+
+	nodeIdentity = identity
+
+	return nil
+}
+
+// flagNodeDetection creates the node identity detection flags
+func flagNodeDetection(fs *pflag.FlagSet) {
+	fs.StringVar(&nodeDetector.Source, "node-name-source", "", "detect the node name when --node-name is not set (hostname|fqdn|aws|gcp|azure|openstack)")
+	fs.StringVar(&nodeDetector.MetadataEndpoint, "node-metadata-endpoint", "", "override the instance metadata address used by the aws, gcp and azure node name sources")
+	fs.StringVar(&nodeDetector.ConfigDrivePath, "node-openstack-config-drive-path", node.DefaultConfigDrivePath, "mount path of the openstack config drive used by the openstack node name source")
+}
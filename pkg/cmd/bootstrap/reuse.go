@@ -0,0 +1,120 @@
+package bootstrap
+
+import (
+	"crypto"
+	"crypto/x509"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+// checkExistingKubeconfig returns nil if the kubeconfig at path already holds
+// a certificate for the requested node, signed by the vault ca and valid for
+// at least minValidity, otherwise it returns the reason it cannot be reused.
+// The ca in the kubeconfig is not trusted, as it comes from the same file as
+// the certificate it would verify, so a kubeconfig issued by another ca would
+// always pass.
+func checkExistingKubeconfig(path string, minValidity time.Duration) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	config, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		return errors.Wrap(err, "loading kubeconfig")
+	}
+
+	current := config.Contexts[kubeconfig.Names.Context]
+	if current == nil {
+		return errors.Errorf("context %q not found", kubeconfig.Names.Context)
+	}
+
+	cluster := config.Clusters[current.Cluster]
+	if cluster == nil {
+		return errors.Errorf("cluster %q not found", current.Cluster)
+	}
+
+	if cluster.Server != kubeconfig.MasterAddr {
+		return errors.Errorf("server %q does not match %q", cluster.Server, kubeconfig.MasterAddr)
+	}
+
+	authInfo := config.AuthInfos[current.AuthInfo]
+	if authInfo == nil {
+		return errors.Errorf("user %q not found", current.AuthInfo)
+	}
+
+	certs, err := certutil.ParseCertsPEM(authInfo.ClientCertificateData)
+	if err != nil {
+		return errors.Wrap(err, "parsing client certificate")
+	}
+
+	crt := certs[0]
+
+	key, err := certutil.ParsePrivateKeyPEM(authInfo.ClientKeyData)
+	if err != nil {
+		return errors.Wrap(err, "parsing client key")
+	}
+
+	if !publicKeyMatches(crt, key) {
+		return errors.New("client key does not match the certificate")
+	}
+
+	cas, err := vaultCA()
+	if err != nil {
+		return err
+	}
+
+	roots := x509.NewCertPool()
+	for _, ca := range cas {
+		roots.AddCert(ca)
+	}
+
+	_, err = crt.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "verifying client certificate")
+	}
+
+	if crt.Subject.CommonName != "system:node:"+nodeName {
+		return errors.Errorf("certificate is for %q not node %q", crt.Subject.CommonName, nodeName)
+	}
+
+	// the group is only known up front when it is set by this tool rather than the vault role
+	if mode == ModeSignVerbatim && !reflect.DeepEqual(crt.Subject.Organization, []string{groupName}) {
+		return errors.Errorf("certificate groups %v do not match %q", crt.Subject.Organization, groupName)
+	}
+
+	if left := time.Until(crt.NotAfter); left < minValidity {
+		return errors.Errorf("certificate is only valid for %s", left)
+	}
+
+	return nil
+}
+
+// publicKeyMatches checks the private key belongs to the certificate
+func publicKeyMatches(crt *x509.Certificate, key interface{}) bool {
+	signer, ok := key.(crypto.Signer)
+
+	return ok && reflect.DeepEqual(crt.PublicKey, signer.Public())
+}
+
+// vaultCA reads the ca of the vault pki mount from disk, so an existing
+// kubeconfig can be reused without contacting vault. The ca is read from
+// --skip-if-valid-for-ca-path, or from --output-ca-path written by an earlier
+// run.
+func vaultCA() ([]*x509.Certificate, error) {
+	path := reuseCAPath
+	if path == "" {
+		path = caPath
+	}
+
+	cas, err := certutil.CertsFromFile(path)
+	return cas, errors.Wrap(err, "reading vault ca")
+}
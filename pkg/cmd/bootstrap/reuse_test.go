@@ -0,0 +1,165 @@
+package bootstrap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestCheckExistingKubeconfig(t *testing.T) {
+	client, stop := testVaultServer(t)
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer os.Setenv(api.EnvVaultToken, os.Getenv(api.EnvVaultToken))
+	os.Setenv(api.EnvVaultToken, client.Token())
+
+	path := filepath.Join(dir, "kubeconfig")
+	caPath := filepath.Join(dir, "ca.crt")
+
+	flags := map[string]string{
+		"node-name":                    "k-a-node-s36b",
+		"vault-address":                client.Address(),
+		"vault-pki-role":               "test",
+		"output-kubeconfig-master-url": "https://apiserver:6443",
+		"output-kubeconfig-path":       path,
+		"output-ca-path":               caPath,
+	}
+
+	if err := validate(resetFlags(t, flags)); err != nil {
+		t.Fatalf("invalid flags: %s", err)
+	}
+
+	if err := checkExistingKubeconfig(path, time.Minute); err == nil {
+		t.Errorf("expected missing kubeconfig to not be reusable")
+	}
+
+	if err := run(); err != nil {
+		t.Fatalf("error running bootstrap: %s", err)
+	}
+
+	if err := checkExistingKubeconfig(path, 10*time.Minute); err != nil {
+		t.Errorf("expected kubeconfig to be reusable but got: %s", err)
+	}
+
+	if err := checkExistingKubeconfig(path, 2*time.Hour); err == nil {
+		t.Errorf("expected kubeconfig expiring within 2h to not be reusable")
+	}
+
+	nodeName = "another-node"
+	if err := checkExistingKubeconfig(path, 10*time.Minute); err == nil {
+		t.Errorf("expected kubeconfig for another node to not be reusable")
+	}
+	nodeName = "k-a-node-s36b"
+
+	// the ca in the kubeconfig is not trusted to verify the client certificate
+	otherPath := filepath.Join(dir, "other-kubeconfig")
+	writeKubeconfigWithOtherCA(t, otherPath, "https://apiserver:6443", "system:node:k-a-node-s36b")
+
+	if err := checkExistingKubeconfig(otherPath, 10*time.Minute); err == nil {
+		t.Errorf("expected kubeconfig signed by another ca to not be reusable")
+	}
+
+	// a valid kubeconfig is reused without contacting vault
+	flags["vault-address"] = "http://127.0.0.1:1"
+	flags["skip-if-valid-for"] = "10m"
+	flags["skip-if-valid-for-ca-path"] = caPath
+
+	if err := validate(resetFlags(t, flags)); err != nil {
+		t.Fatalf("invalid flags: %s", err)
+	}
+
+	if err := run(); err != nil {
+		t.Errorf("expected existing kubeconfig to be reused but got: %s", err)
+	}
+}
+
+// writeKubeconfigWithOtherCA writes a kubeconfig holding a client certificate
+// signed by a new self signed ca, which is also set as the cluster ca
+func writeKubeconfigWithOtherCA(t *testing.T, path, server, commonName string) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Other CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"system:bootstrappers"}},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := clientcmdapi.NewConfig()
+	config.Clusters[kubeconfig.Names.Cluster] = &clientcmdapi.Cluster{
+		Server:                   server,
+		CertificateAuthorityData: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+	}
+	config.AuthInfos[kubeconfig.Names.User] = &clientcmdapi.AuthInfo{
+		ClientCertificateData: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		ClientKeyData:         pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+	config.Contexts[kubeconfig.Names.Context] = &clientcmdapi.Context{
+		Cluster:  kubeconfig.Names.Cluster,
+		AuthInfo: kubeconfig.Names.User,
+	}
+	config.CurrentContext = kubeconfig.Names.Context
+
+	if err := clientcmd.WriteToFile(*config, path); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,182 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Secret output formats
+const (
+	SecretFormatKubeconfig = "kubeconfig"
+	SecretFormatTLS        = "tls"
+)
+
+// applyPatchType is the content type of server-side apply requests
+const applyPatchType = types.PatchType("application/apply-patch+yaml")
+
+// fieldManager is the field manager used for server-side apply
+const fieldManager = "k8s-vault-csr"
+
+var (
+	// Secret output flags
+	secretName          string
+	secretNamespace     string
+	secretFormat        string
+	secretKubeconfigKey string
+	secretCAKey         string
+	secretLabelFlags    []string
+	secretLabels        map[string]string
+	secretMasterAddr    string
+	secretKubeconfig    string
+)
+
+// validateSecret checks the secret output flags
+func validateSecret() error {
+	secretLabels = nil
+
+	if secretName == "" {
+		return nil
+	}
+
+	if secretNamespace == "" {
+		return errors.New("--output-secret-namespace is required")
+	}
+
+	switch secretFormat {
+	case SecretFormatKubeconfig:
+		if secretKubeconfigKey == "" {
+			return errors.New("--output-secret-kubeconfig-key is required with --output-secret-format=kubeconfig")
+		}
+	case SecretFormatTLS:
+	default:
+		return errors.Errorf("unknown secret format %q, must be one of %s|%s", secretFormat, SecretFormatKubeconfig, SecretFormatTLS)
+	}
+
+	for _, label := range secretLabelFlags {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return errors.Errorf("invalid --output-secret-label %q, must be in the format key=value", label)
+		}
+
+		if secretLabels == nil {
+			secretLabels = map[string]string{}
+		}
+
+		secretLabels[parts[0]] = parts[1]
+	}
+
+	return nil
+}
+
+// newSecret creates the secret holding the bootstrap credentials, the
+// kubeconfig is only used in the kubeconfig format
+func newSecret(key, cert, ca, kubeconfigData []byte) *v1.Secret {
+	secret := &v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: secretNamespace,
+			Labels:    secretLabels,
+		},
+	}
+
+	if secretFormat == SecretFormatTLS {
+		secret.Type = v1.SecretTypeTLS
+		secret.Data = map[string][]byte{
+			v1.TLSCertKey:       cert,
+			v1.TLSPrivateKeyKey: key,
+		}
+
+		if secretCAKey != "" {
+			secret.Data[secretCAKey] = ca
+		}
+
+		return secret
+	}
+
+	secret.Type = v1.SecretTypeOpaque
+	secret.Data = map[string][]byte{
+		secretKubeconfigKey: kubeconfigData,
+	}
+
+	return secret
+}
+
+// applySecret creates or updates the secret using server-side apply, so
+// repeated runs only change the fields owned by this tool. The type of a
+// secret cannot be changed, so an existing secret of another type is an error.
+func applySecret(client rest.Interface, secret *v1.Secret) error {
+	var existing v1.Secret
+
+	err := client.Get().
+		Namespace(secret.Namespace).
+		Resource("secrets").
+		Name(secret.Name).
+		Do().
+		Into(&existing)
+
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return errors.Wrapf(err, "get secret %s/%s", secret.Namespace, secret.Name)
+	case existing.Type != secret.Type:
+		return errors.Errorf("secret %s/%s has type %s but --output-secret-format=%s needs %s, the type of a secret cannot be changed so delete it first", secret.Namespace, secret.Name, existing.Type, secretFormat, secret.Type)
+	}
+
+	data, err := json.Marshal(secret)
+	if err != nil {
+		return errors.Wrap(err, "marshal secret")
+	}
+
+	err = client.Patch(applyPatchType).
+		Namespace(secret.Namespace).
+		Resource("secrets").
+		Name(secret.Name).
+		Param("fieldManager", fieldManager).
+		Param("force", "true").
+		Body(data).
+		Do().
+		Error()
+
+	return errors.Wrapf(err, "apply secret %s/%s", secret.Namespace, secret.Name)
+}
+
+// writeSecret writes the bootstrap credentials to the configured secret
+func writeSecret(key, cert, ca, kubeconfigData []byte) error {
+	config, err := clientcmd.BuildConfigFromFlags(secretMasterAddr, secretKubeconfig)
+	if err != nil {
+		return errors.Wrap(err, "building kubernetes config for secret output")
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return errors.Wrap(err, "create kubernetes client for secret output")
+	}
+
+	return applySecret(clientset.CoreV1().RESTClient(), newSecret(key, cert, ca, kubeconfigData))
+}
+
+// flagSecret creates the secret output flags
+func flagSecret(fs *pflag.FlagSet) {
+	fs.StringVar(&secretName, "output-secret-name", "", "name of a secret to write the bootstrap credentials to")
+	fs.StringVar(&secretNamespace, "output-secret-namespace", "default", "namespace of the output secret")
+	fs.StringVar(&secretFormat, "output-secret-format", SecretFormatKubeconfig, "format of the output secret (kubeconfig|tls)")
+	fs.StringVar(&secretKubeconfigKey, "output-secret-kubeconfig-key", "kubeconfig", "key of the kubeconfig in the output secret")
+	fs.StringVar(&secretCAKey, "output-secret-ca-key", "ca.crt", "key of the ca bundle in a tls output secret, not written if empty")
+	fs.StringSliceVar(&secretLabelFlags, "output-secret-label", nil, "label of the output secret in the format key=value, may be repeated")
+	fs.StringVar(&secretMasterAddr, "output-secret-master", "", "url of the apiserver to write the output secret to")
+	fs.StringVar(&secretKubeconfig, "output-secret-kubeconfig", "", "kubeconfig of the cluster to write the output secret to, defaults to in cluster config")
+}
@@ -0,0 +1,213 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// fakeSecretServer is an apiserver that records server-side apply requests
+// for secrets
+type fakeSecretServer struct {
+	t       *testing.T
+	lock    sync.Mutex
+	secrets []v1.Secret
+}
+
+func (s *fakeSecretServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v1/namespaces/kube-system/secrets/bootstrap" {
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == "GET" {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if len(s.secrets) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(metav1.Status{
+				TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+				Status:   metav1.StatusFailure,
+				Reason:   metav1.StatusReasonNotFound,
+				Code:     http.StatusNotFound,
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(s.secrets[len(s.secrets)-1])
+		return
+	}
+
+	if r.Method != "PATCH" {
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if contentType := r.Header.Get("Content-Type"); contentType != string(applyPatchType) {
+		s.t.Errorf("expected content type %q, got %q", applyPatchType, contentType)
+	}
+
+	if manager := r.URL.Query().Get("fieldManager"); manager != fieldManager {
+		s.t.Errorf("expected field manager %q, got %q", fieldManager, manager)
+	}
+
+	if force := r.URL.Query().Get("force"); force != "true" {
+		s.t.Errorf("expected apply to be forced")
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.t.Errorf("reading request body: %s", err)
+	}
+
+	var secret v1.Secret
+	if err := json.Unmarshal(body, &secret); err != nil {
+		s.t.Errorf("decoding secret: %s", err)
+	}
+
+	s.lock.Lock()
+	s.secrets = append(s.secrets, secret)
+	s.lock.Unlock()
+
+	w.Write(body)
+}
+
+func TestRunSecret(t *testing.T) {
+	client, stop := testVaultServer(t)
+	defer stop()
+
+	defer os.Setenv(api.EnvVaultToken, os.Getenv(api.EnvVaultToken))
+	os.Setenv(api.EnvVaultToken, client.Token())
+
+	cases := []struct {
+		format string
+		keys   []string
+		typ    v1.SecretType
+	}{
+		{SecretFormatKubeconfig, []string{"bootstrap.kubeconfig"}, v1.SecretTypeOpaque},
+		{SecretFormatTLS, []string{"ca.crt", "tls.crt", "tls.key"}, v1.SecretTypeTLS},
+	}
+
+	for _, c := range cases {
+		fake := &fakeSecretServer{t: t}
+		server := httptest.NewServer(fake)
+
+		flags := map[string]string{
+			"node-name":                    "k-a-node-s36b",
+			"vault-pki-role":               "test",
+			"vault-address":                client.Address(),
+			"output-kubeconfig-master-url": "https://apiserver:6443",
+			"output-secret-name":           "bootstrap",
+			"output-secret-namespace":      "kube-system",
+			"output-secret-format":         c.format,
+			"output-secret-kubeconfig-key": "bootstrap.kubeconfig",
+			"output-secret-label":          "app=kubelet",
+			"output-secret-master":         server.URL,
+		}
+
+		if err := validate(resetFlags(t, flags)); err != nil {
+			t.Fatalf("%s: invalid flags: %s", c.format, err)
+		}
+
+		// the second run updates the secret created by the first
+		for i := 0; i < 2; i++ {
+			if err := run(); err != nil {
+				t.Errorf("%s: error running bootstrap: %s", c.format, err)
+			}
+		}
+
+		server.Close()
+
+		if len(fake.secrets) != 2 {
+			t.Errorf("%s: expected 2 apply requests, got %d", c.format, len(fake.secrets))
+			continue
+		}
+
+		secret := fake.secrets[1]
+
+		if secret.Kind != "Secret" || secret.APIVersion != "v1" {
+			t.Errorf("%s: expected secret type meta, got %s/%s", c.format, secret.APIVersion, secret.Kind)
+		}
+
+		if secret.Type != c.typ {
+			t.Errorf("%s: expected secret type %q, got %q", c.format, c.typ, secret.Type)
+		}
+
+		if !reflect.DeepEqual(secret.Labels, map[string]string{"app": "kubelet"}) {
+			t.Errorf("%s: unexpected labels %v", c.format, secret.Labels)
+		}
+
+		for _, key := range c.keys {
+			if len(secret.Data[key]) == 0 {
+				t.Errorf("%s: expected key %q in secret", c.format, key)
+			}
+		}
+
+		if len(secret.Data) != len(c.keys) {
+			t.Errorf("%s: expected %d keys in secret, got %d", c.format, len(c.keys), len(secret.Data))
+		}
+
+		if c.format != SecretFormatKubeconfig {
+			continue
+		}
+
+		config, err := clientcmd.Load(secret.Data["bootstrap.kubeconfig"])
+		if err != nil {
+			t.Errorf("%s: error loading kubeconfig: %s", c.format, err)
+			continue
+		}
+
+		if cluster := config.Clusters[config.Contexts[config.CurrentContext].Cluster]; cluster == nil || cluster.Server != "https://apiserver:6443" {
+			t.Errorf("%s: expected kubeconfig cluster for https://apiserver:6443", c.format)
+		}
+	}
+}
+
+func TestWriteSecretTypeChange(t *testing.T) {
+	existing := v1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "bootstrap", Namespace: "kube-system"},
+		Type:       v1.SecretTypeOpaque,
+	}
+
+	fake := &fakeSecretServer{t: t, secrets: []v1.Secret{existing}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	resetFlags(t, map[string]string{
+		"output-secret-name":      "bootstrap",
+		"output-secret-namespace": "kube-system",
+		"output-secret-format":    SecretFormatTLS,
+		"output-secret-master":    server.URL,
+	})
+	secretLabelFlags = nil
+
+	if err := validateSecret(); err != nil {
+		t.Fatalf("invalid flags: %s", err)
+	}
+
+	err := writeSecret([]byte("key"), []byte("cert"), []byte("ca"), nil)
+	if err == nil || !strings.Contains(err.Error(), "cannot be changed") {
+		t.Errorf("expected an error changing the secret type, got: %v", err)
+	}
+
+	if len(fake.secrets) != 1 {
+		t.Errorf("expected the secret not to be applied")
+	}
+}
@@ -0,0 +1,175 @@
+package bootstrap
+
+import (
+	"net"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/controller/certificate/bootstrap"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/util"
+)
+
+var (
+	// Serving flags
+	servingDNSNames    []string
+	servingIPs         []string
+	servingIPAddresses []net.IP
+	servingTTL         string
+)
+
+var servingCmd = &cobra.Command{
+	Use:   "serving",
+	Short: "create kubelet serving certificate using vault",
+	Args:  cobra.NoArgs,
+	Long: `Create a kubelet serving certificate for the "system:node:<node-name>" user.
+
+  The certificate is issued for server auth with the DNS names and IP
+  addresses from --dns-name and --ip-address. If neither are set they are
+  taken from --node-name-source, or detected from the hostname and the
+  addresses of the local interfaces.
+
+  The certificate and key are written to --output-cert-path and
+  --output-key-path, which should match the --tls-cert-file and
+  --tls-private-key-file flags of kubelet.
+
+  In the issue and sign modes the role must allow server certificates with
+  the requested subject alternative names. The issued certificate is checked
+  for server auth and the requested names before it is written.
+
+  Unlike the bootstrap certificate, the serving certificate is used until it
+  is replaced, so --vault-pki-ttl defaults to 720h. Run the command again to
+  renew it before it expires.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := validateServing(cmd.Flags()); err != nil {
+			glog.Exitf("invalid flags: %s", err)
+		}
+
+		if err := runServing(); err != nil {
+			glog.Exit(err)
+		}
+	},
+}
+
+// validateServing checks the flags required by the serving certificate and
+// detects its addresses when none are given
+func validateServing(fs *pflag.FlagSet) error {
+	switch mode {
+	case ModeIssue, ModeSign:
+		if pkiRole == "" {
+			return errors.Errorf("--vault-pki-role is required with --mode=%s", mode)
+		}
+	case ModeSignVerbatim:
+	default:
+		return errors.Errorf("unknown mode %q, must be one of %s|%s|%s", mode, ModeIssue, ModeSign, ModeSignVerbatim)
+	}
+
+	if err := detectNode(); err != nil {
+		return err
+	}
+
+	if nodeName == "" {
+		return errors.New("--node-name or --node-name-source is required")
+	}
+
+	if mode == ModeIssue && keyConfig != (bootstrap.KeyConfig{}) {
+		return errors.Errorf("--key-algorithm and --key-size cannot be used with --mode=%s, the key type and size are set by the vault role", mode)
+	}
+
+	if _, err := keyConfig.Complete(); err != nil {
+		return errors.Wrap(err, "invalid --key-algorithm or --key-size")
+	}
+
+	if certPath == "" || keyPath == "" {
+		return errors.New("--output-cert-path and --output-key-path are required")
+	}
+
+	owner, err := util.ParseFileOwner(outputOwner)
+	if err != nil {
+		return errors.Wrap(err, "invalid --output-owner")
+	}
+
+	fileOwner = owner
+
+	if tokenCacheFile != "" && revokeToken {
+		return errors.New("--vault-token-cache-file cannot be used with --vault-revoke-token")
+	}
+
+	servingIPAddresses = nil
+	for _, s := range servingIPs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return errors.Errorf("invalid --ip-address %q", s)
+		}
+
+		servingIPAddresses = append(servingIPAddresses, ip)
+	}
+
+	if len(servingDNSNames) == 0 && len(servingIPAddresses) == 0 && nodeIdentity != nil {
+		servingDNSNames, servingIPAddresses = nodeIdentity.DNSNames, nodeIdentity.IPAddresses
+	}
+
+	if len(servingDNSNames) == 0 && len(servingIPAddresses) == 0 {
+		servingDNSNames, servingIPAddresses, err = util.DetectAddresses()
+		if err != nil {
+			return errors.Wrap(err, "detecting addresses")
+		}
+
+		glog.Infof("detected dns names %v and ip addresses %v", servingDNSNames, servingIPAddresses)
+	}
+
+	return nil
+}
+
+func runServing() error {
+	client, renewer, err := login()
+	if err != nil {
+		return err
+	}
+
+	identity := bootstrap.ServingIdentity(nodeName, servingDNSNames, servingIPAddresses)
+	key, cert, ca, err := bootstrap.CreateCert(client, mode, pkiMount, pkiRole, servingTTL, identity, keyConfig)
+
+	// the token is no longer needed once the certificate is issued
+	if revokeToken {
+		if err := renewer.RevokeSelf(); err != nil {
+			glog.Warningf("revoke vault token: %s", err)
+		}
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "generate serving certificate")
+	}
+
+	if err := util.WriteFileAtomic(certPath, cert, 0644, fileOwner); err != nil {
+		return errors.Wrap(err, "write certificate to disk")
+	}
+
+	if err := util.WriteFileAtomic(keyPath, key, 0600, fileOwner); err != nil {
+		return errors.Wrap(err, "write key to disk")
+	}
+
+	if caPath != "" {
+		if err := util.WriteFileAtomic(caPath, ca, 0644, fileOwner); err != nil {
+			return errors.Wrap(err, "write ca to disk")
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	servingCmd.Flags().StringVar(&mode, "mode", ModeIssue, "how to create the serving certificate (issue|sign|sign-verbatim)")
+	servingCmd.Flags().StringVar(&nodeName, "node-name", "", "node name to use in the serving certificate")
+	servingCmd.Flags().StringSliceVar(&servingDNSNames, "dns-name", nil, "dns name of the node, may be repeated, detected if no dns names or ip addresses are set")
+	servingCmd.Flags().StringSliceVar(&servingIPs, "ip-address", nil, "ip address of the node, may be repeated, detected if no dns names or ip addresses are set")
+	servingCmd.Flags().StringVar(&certPath, "output-cert-path", "", "path to write the PEM encoded certificate to")
+	servingCmd.Flags().StringVar(&keyPath, "output-key-path", "", "path to write the PEM encoded private key to")
+	servingCmd.Flags().StringVar(&caPath, "output-ca-path", "", "path to write the PEM encoded ca bundle to")
+
+	flagNodeDetection(servingCmd.Flags())
+	flagVault(servingCmd.Flags(), &servingTTL, "720h")
+
+	Cmd.AddCommand(servingCmd)
+}
@@ -0,0 +1,167 @@
+package bootstrap
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/util/cert"
+)
+
+// resetServingFlags resets the serving command flags to their defaults and
+// sets the given flags, returning the flag set so it can be validated
+func resetServingFlags(t *testing.T, flags map[string]string) *pflag.FlagSet {
+	servingCmd.Flags().VisitAll(func(f *pflag.Flag) {
+		f.Value.Set(f.DefValue)
+		f.Changed = false
+	})
+
+	vaultAuth = nil
+	servingDNSNames = nil
+	servingIPs = nil
+
+	for name, value := range flags {
+		if err := servingCmd.Flags().Set(name, value); err != nil {
+			t.Fatalf("setting flag %s: %s", name, err)
+		}
+	}
+
+	return servingCmd.Flags()
+}
+
+func TestValidateServing(t *testing.T) {
+	base := map[string]string{
+		"node-name":        "k-a-node-s36b",
+		"vault-pki-role":   "test",
+		"output-cert-path": "/tmp/kubelet.crt",
+		"output-key-path":  "/tmp/kubelet.key",
+	}
+
+	cases := []struct {
+		name  string
+		flags map[string]string
+		valid bool
+	}{
+		{"explicit addresses", map[string]string{"dns-name": "k-a-node-s36b.local", "ip-address": "10.0.0.10"}, true},
+		{"detected addresses", map[string]string{}, true},
+		{"bad ip address", map[string]string{"ip-address": "10.0.0"}, false},
+		{"no key path", map[string]string{"output-key-path": ""}, false},
+		{"no node name", map[string]string{"node-name": ""}, false},
+		{"no role", map[string]string{"vault-pki-role": ""}, false},
+		{"sign-verbatim without role", map[string]string{"vault-pki-role": "", "mode": "sign-verbatim"}, true},
+		{"sign with key", map[string]string{"mode": "sign", "key-algorithm": "rsa"}, true},
+		{"issue with key", map[string]string{"key-algorithm": "rsa"}, false},
+	}
+
+	for _, c := range cases {
+		flags := map[string]string{}
+		for k, v := range base {
+			flags[k] = v
+		}
+		for k, v := range c.flags {
+			flags[k] = v
+		}
+
+		err := validateServing(resetServingFlags(t, flags))
+		if c.valid && err != nil {
+			t.Errorf("%s: expected flags to be valid but got: %s", c.name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: expected flags to be invalid", c.name)
+		}
+	}
+
+	if err := validateServing(resetServingFlags(t, base)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(servingDNSNames) == 0 {
+		t.Errorf("expected the hostname to be detected")
+	}
+}
+
+func TestRunServing(t *testing.T) {
+	client, stop := testVaultServer(t)
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer os.Setenv(api.EnvVaultToken, os.Getenv(api.EnvVaultToken))
+	os.Setenv(api.EnvVaultToken, client.Token())
+
+	certFile := filepath.Join(dir, "kubelet.crt")
+	keyFile := filepath.Join(dir, "kubelet.key")
+
+	flags := map[string]string{
+		"mode":             "sign-verbatim",
+		"node-name":        "k-a-node-s36b",
+		"dns-name":         "k-a-node-s36b.local",
+		"ip-address":       "10.0.0.10",
+		"vault-address":    client.Address(),
+		"output-cert-path": certFile,
+		"output-key-path":  keyFile,
+	}
+
+	if err := validateServing(resetServingFlags(t, flags)); err != nil {
+		t.Fatalf("invalid flags: %s", err)
+	}
+
+	if err := runServing(); err != nil {
+		t.Fatalf("error running serving bootstrap: %s", err)
+	}
+
+	keyData, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cert.ParsePrivateKeyPEM(keyData); err != nil {
+		t.Errorf("invalid key: %s", err)
+	}
+
+	certs, err := cert.CertsFromFile(certFile)
+	if err != nil {
+		t.Fatalf("invalid certificate: %s", err)
+	}
+
+	crt := certs[0]
+
+	if crt.Subject.CommonName != "system:node:k-a-node-s36b" {
+		t.Errorf("expected common name of 'system:node:k-a-node-s36b', but got: %v", crt.Subject.CommonName)
+	}
+	if !reflect.DeepEqual(crt.Subject.Organization, []string{"system:nodes"}) {
+		t.Errorf("expected organization to be [system:nodes] but got: %v", crt.Subject.Organization)
+	}
+	if !reflect.DeepEqual(crt.DNSNames, []string{"k-a-node-s36b.local"}) {
+		t.Errorf("expected dns names to be [k-a-node-s36b.local] but got: %v", crt.DNSNames)
+	}
+	if len(crt.IPAddresses) != 1 || !crt.IPAddresses[0].Equal(net.ParseIP("10.0.0.10")) {
+		t.Errorf("expected ip addresses to be [10.0.0.10] but got: %v", crt.IPAddresses)
+	}
+	if !reflect.DeepEqual(crt.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}) {
+		t.Errorf("bad extended key usage")
+	}
+}
+
+func TestServingTTL(t *testing.T) {
+	resetFlags(t, nil)
+	resetServingFlags(t, nil)
+
+	if pkiTTL != "1h" {
+		t.Errorf("expected bootstrap ttl of 1h, got %s", pkiTTL)
+	}
+
+	if servingTTL != "720h" {
+		t.Errorf("expected serving ttl of 720h, got %s", servingTTL)
+	}
+}
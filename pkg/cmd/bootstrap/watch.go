@@ -0,0 +1,178 @@
+package bootstrap
+
+import (
+	"context"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/vault/token"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/client-go/tools/clientcmd"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+// runWatch keeps the vault token alive and re-issues the certificate each time
+// it reaches the renewal point of its lifetime, until a termination signal is
+// received
+func runWatch(client *api.Client, renewer *token.Renewer) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg, ctx := errgroup.WithContext(ctx)
+
+	wg.Go(func() error {
+		return renewer.Run(ctx.Done())
+	})
+
+	wg.Go(func() error {
+		rotate(func() error { return issue(client) }, ctx.Done())
+		return nil
+	})
+
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case <-term:
+		glog.Info("received SIGTERM, exiting gracefully...")
+	case <-ctx.Done():
+	}
+
+	cancel()
+	err := wg.Wait()
+
+	// rotate has returned, so revoking cannot fail an issue in progress
+	if revokeToken {
+		if err := renewer.RevokeSelf(); err != nil {
+			glog.Warningf("revoke vault token: %s", err)
+		}
+	}
+
+	return err
+}
+
+// rotate issues a certificate whenever the current one is due for renewal. If
+// the current certificate cannot be read it is replaced after the retry
+// interval, so an unreadable output does not issue a certificate in a loop.
+func rotate(issue func() error, done <-chan struct{}) {
+	for {
+		wait := time.Duration(0)
+
+		crt, err := currentCertificate()
+		if err != nil {
+			glog.Warningf("reading current certificate, replacing it in %s: %s", retryInterval, err)
+			wait = retryInterval
+		} else if crt != nil {
+			renewAt := renewalTime(crt, renewFraction)
+			wait = time.Until(renewAt)
+
+			if wait > 0 {
+				glog.Infof("certificate expires at %s, renewing at %s", crt.NotAfter, renewAt)
+			}
+		}
+
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-done:
+				return
+			}
+		}
+
+		if err := issue(); err != nil {
+			glog.Errorf("rotating certificate, retrying in %s: %s", retryInterval, err)
+
+			select {
+			case <-time.After(retryInterval):
+				continue
+			case <-done:
+				return
+			}
+		}
+
+		glog.Info("rotated bootstrap certificate")
+
+		if postRotateCommand != "" {
+			if err := runHook(postRotateCommand); err != nil {
+				glog.Warningf("running post rotate command: %s", err)
+			}
+		}
+	}
+}
+
+// renewalTime is the point in the certificate lifetime at which it should be
+// re-issued
+func renewalTime(crt *x509.Certificate, fraction float64) time.Time {
+	lifetime := crt.NotAfter.Sub(crt.NotBefore)
+	return crt.NotBefore.Add(time.Duration(float64(lifetime) * fraction))
+}
+
+// currentCertificate reads the certificate from the first configured output,
+// returning nil if it has not been written yet
+func currentCertificate() (*x509.Certificate, error) {
+	var data []byte
+	var err error
+
+	switch {
+	case certPath != "":
+		data, err = ioutil.ReadFile(certPath)
+	case pemPath != "":
+		data, err = ioutil.ReadFile(pemPath)
+	case kubeconfig.Path != "":
+		data, err = kubeconfigCertificate(kubeconfig.Path)
+	}
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the pem output also contains the private key, which is skipped
+	certs, err := certutil.ParseCertsPEM(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing certificate")
+	}
+
+	return certs[0], nil
+}
+
+// kubeconfigCertificate returns the client certificate of the bootstrap context
+func kubeconfigCertificate(path string) ([]byte, error) {
+	config, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := config.Contexts[kubeconfig.Names.Context]
+	if current == nil {
+		return nil, errors.Errorf("context %q not found in kubeconfig", kubeconfig.Names.Context)
+	}
+
+	authInfo := config.AuthInfos[current.AuthInfo]
+	if authInfo == nil {
+		return nil, errors.Errorf("user %q not found in kubeconfig", current.AuthInfo)
+	}
+
+	if authInfo.ClientCertificate != "" {
+		return ioutil.ReadFile(authInfo.ClientCertificate)
+	}
+
+	return authInfo.ClientCertificateData, nil
+}
+
+// runHook runs a shell command, forwarding its output
+func runHook(command string) error {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,163 @@
+package bootstrap
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/cert"
+)
+
+func TestRenewalTime(t *testing.T) {
+	now := time.Now()
+
+	crt := &x509.Certificate{
+		NotBefore: now,
+		NotAfter:  now.Add(time.Hour),
+	}
+
+	if renewAt := renewalTime(crt, 0.5); !renewAt.Equal(now.Add(30 * time.Minute)) {
+		t.Errorf("expected renewal half way through lifetime but got: %s", renewAt.Sub(now))
+	}
+
+	if renewAt := renewalTime(crt, 0.75); !renewAt.Equal(now.Add(45 * time.Minute)) {
+		t.Errorf("expected renewal three quarters through lifetime but got: %s", renewAt.Sub(now))
+	}
+}
+
+func TestCurrentCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := cert.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crt, err := cert.NewSelfSignedCACert(cert.Config{CommonName: "system:node:k-a-node-s36b"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyData := cert.EncodePrivateKeyPEM(key)
+	certData := cert.EncodeCertPEM(crt)
+
+	cases := []struct {
+		name  string
+		flags map[string]string
+	}{
+		{"cert", map[string]string{"output-cert-path": filepath.Join(dir, "cert.crt"), "output-key-path": filepath.Join(dir, "cert.key")}},
+		{"pem", map[string]string{"output-pem-path": filepath.Join(dir, "bundle.pem")}},
+		{"kubeconfig", map[string]string{"output-kubeconfig-path": filepath.Join(dir, "kubeconfig")}},
+	}
+
+	for _, c := range cases {
+		resetFlags(t, c.flags)
+
+		current, err := currentCertificate()
+		if err != nil {
+			t.Errorf("%s: unexpected error reading missing certificate: %s", c.name, err)
+		} else if current != nil {
+			t.Errorf("%s: expected no certificate before it is written", c.name)
+		}
+
+		if err := writeOutput(keyData, certData, certData); err != nil {
+			t.Errorf("%s: failed to write output: %s", c.name, err)
+			continue
+		}
+
+		current, err = currentCertificate()
+		if err != nil {
+			t.Errorf("%s: failed to read certificate: %s", c.name, err)
+			continue
+		}
+
+		if current == nil || !current.Equal(crt) {
+			t.Errorf("%s: expected to read back the written certificate", c.name)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := cert.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// self signed certificates are valid for ten years, so are never due
+	crt, err := cert.NewSelfSignedCACert(cert.Config{CommonName: "system:node:k-a-node-s36b"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyData := cert.EncodePrivateKeyPEM(key)
+	certData := cert.EncodeCertPEM(crt)
+
+	cases := []struct {
+		name    string
+		current []byte
+		delayed bool
+		issued  int
+	}{
+		{"missing", nil, false, 1},
+		{"unreadable", []byte("not a certificate"), true, 1},
+		{"valid", certData, false, 0},
+	}
+
+	for _, c := range cases {
+		certPath := filepath.Join(dir, c.name+".crt")
+
+		resetFlags(t, map[string]string{
+			"output-cert-path":     certPath,
+			"output-key-path":      filepath.Join(dir, c.name+".key"),
+			"watch-retry-interval": "100ms",
+		})
+
+		if c.current != nil {
+			if err := ioutil.WriteFile(certPath, c.current, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		issued := make(chan struct{}, 10)
+		issue := func() error {
+			issued <- struct{}{}
+			return writeOutput(keyData, certData, certData)
+		}
+
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			rotate(issue, done)
+			close(stopped)
+		}()
+
+		if c.delayed {
+			select {
+			case <-issued:
+				t.Errorf("%s: expected no certificate to be issued before the retry interval", c.name)
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+
+		time.Sleep(300 * time.Millisecond)
+		close(done)
+		<-stopped
+
+		if count := len(issued); count != c.issued {
+			t.Errorf("%s: expected %d certificates to be issued, got %d", c.name, c.issued, count)
+		}
+	}
+}
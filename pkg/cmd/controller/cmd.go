@@ -2,6 +2,8 @@ package controller
 
 import (
 	"context"
+	"expvar"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,6 +14,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/controller/certificate/signer"
 	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/util"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/vault/health"
 	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/vault/token"
 	"golang.org/x/sync/errgroup"
 	"k8s.io/client-go/informers"
@@ -25,15 +28,22 @@ var (
 	kubeconfig string
 
 	// Vault generic flags
-	vaultAddr string
-	vaultAuth token.AuthProvider
+	vaultAddr   string
+	vaultAuth   token.AuthProvider
+	revokeToken bool
 
 	// Controller flags
-	workers int
+	workers        int
+	metricsAddr    string
+	healthAddr     string
+	healthInterval time.Duration
 
 	// Vault PKI flags
 	pkiMount string
 	pkiRole  string
+
+	// Signer flags
+	signerOptions signer.Options
 )
 
 var Cmd = &cobra.Command{
@@ -49,6 +59,18 @@ var Cmd = &cobra.Command{
   It also requires sufficient permissions in vault to call the 
   'sign-verbatim' endpoint on the pki mount`,
 	Run: func(cmd *cobra.Command, args []string) {
+		// creates the in-cluster config
+		config, err := clientcmd.BuildConfigFromFlags(masterAddr, kubeconfig)
+		if err != nil {
+			glog.Exitf("building kubernetes config from flags: %s", err)
+		}
+
+		// creates the clientset
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			glog.Fatalf("create kubernetes config: %s", err)
+		}
+
 		// create vault client
 		client, err := api.NewClient(&api.Config{
 			Address:    vaultAddr,
@@ -59,6 +81,9 @@ var Cmd = &cobra.Command{
 			glog.Exitf("create vault client: %s", err)
 		}
 
+		// allow auth providers to request service account tokens
+		util.SetAuthProviderKubernetesClient(vaultAuth, clientset)
+
 		// create token renewer
 		renewer := token.NewRenewer(client, vaultAuth)
 
@@ -68,17 +93,9 @@ var Cmd = &cobra.Command{
 			glog.Exitf("renewing vault token: %s", err)
 		}
 
-		// creates the in-cluster config
-		config, err := clientcmd.BuildConfigFromFlags(masterAddr, kubeconfig)
-		if err != nil {
-			glog.Exitf("building kubernetes config from flags: %s", err)
-		}
-
-		// creates the clientset
-		clientset, err := kubernetes.NewForConfig(config)
-		if err != nil {
-			glog.Fatalf("create kubernetes config: %s", err)
-		}
+		// pause signing while vault is sealed or unreachable
+		monitor := health.NewMonitor(client, healthInterval)
+		signerOptions.HealthGate = monitor
 
 		// create informer factory
 		factory := informers.NewSharedInformerFactory(clientset, time.Minute*5)
@@ -90,6 +107,7 @@ var Cmd = &cobra.Command{
 			client,
 			pkiMount,
 			pkiRole,
+			signerOptions,
 		)
 
 		if err != nil {
@@ -109,7 +127,48 @@ var Cmd = &cobra.Command{
 			return renewer.Run(ctx.Done())
 		})
 
-		term := make(chan os.Signal)
+		wg.Go(func() error {
+			return monitor.Run(ctx.Done())
+		})
+
+		// readiness is always served, metrics share the mux when both use
+		// the same address
+		muxes := map[string]*http.ServeMux{}
+		for _, addr := range []string{healthAddr, metricsAddr} {
+			if addr != "" && muxes[addr] == nil {
+				muxes[addr] = http.NewServeMux()
+				muxes[addr].HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+					if err := monitor.Err(); err != nil {
+						http.Error(w, err.Error(), http.StatusServiceUnavailable)
+						return
+					}
+
+					w.Write([]byte("ok"))
+				})
+			}
+		}
+
+		if metricsAddr != "" {
+			muxes[metricsAddr].Handle("/debug/vars", expvar.Handler())
+		}
+
+		for addr, mux := range muxes {
+			server := &http.Server{Addr: addr, Handler: mux}
+
+			wg.Go(func() error {
+				if err := server.ListenAndServe(); err != http.ErrServerClosed {
+					return err
+				}
+				return nil
+			})
+
+			wg.Go(func() error {
+				<-ctx.Done()
+				return server.Close()
+			})
+		}
+
+		term := make(chan os.Signal, 1)
 		signal.Notify(term, os.Interrupt, syscall.SIGTERM)
 
 		select {
@@ -119,7 +178,17 @@ var Cmd = &cobra.Command{
 		}
 
 		cancel()
-		if err := wg.Wait(); err != nil {
+		err = wg.Wait()
+
+		// the signing controller waits for in-flight requests, so revoking
+		// afterwards cannot deny a request that is still signing
+		if revokeToken {
+			if err := renewer.RevokeSelf(); err != nil {
+				glog.Warningf("revoke vault token: %s", err)
+			}
+		}
+
+		if err != nil {
 			glog.Fatalf("unhandled error received: %s", err)
 		}
 	},
@@ -130,8 +199,14 @@ func init() {
 	Cmd.Flags().StringVar(&masterAddr, "master", "", "kubernetes master url")
 	Cmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "kubeconfig file to use")
 	Cmd.Flags().StringVar(&vaultAddr, "vault-address", "", "vault server address")
+	Cmd.Flags().BoolVar(&revokeToken, "vault-revoke-token", false, "revoke the vault token on shutdown")
 	Cmd.Flags().IntVar(&workers, "signer-workers", 4, "number of signing workers to run")
+	Cmd.Flags().StringVar(&metricsAddr, "metrics-address", "", "address to serve metrics on at /debug/vars and readiness at /readyz, disabled if empty")
+	Cmd.Flags().StringVar(&healthAddr, "health-address", ":8080", "address to serve readiness on at /readyz, disabled if empty")
+	Cmd.Flags().DurationVar(&healthInterval, "vault-health-interval", 10*time.Second, "interval between vault health checks, signing is paused while vault is unhealthy")
 	Cmd.Flags().StringVar(&pkiMount, "vault-pki-mount", "pki", "specify the pki mount to use to generate certificates")
 	Cmd.Flags().StringVar(&pkiRole, "vault-pki-role", "", "specify role to use, only ttl is used from the role")
+	Cmd.Flags().BoolVar(&signerOptions.IncludeCAChain, "signer-include-ca-chain", false, "append the ca chain returned by vault to issued certificates")
+	Cmd.Flags().BoolVar(&signerOptions.ExcludeRootCA, "signer-exclude-root-ca", false, "leave the root ca out of the appended ca chain")
 	util.FlagAuthProvider(&vaultAuth, Cmd.Flags())
 }
@@ -0,0 +1,236 @@
+package credential
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/controller/certificate/bootstrap"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/util"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/vault/token"
+)
+
+var (
+	// Credential flags
+	commonName string
+	apiVersion string
+	mode       string
+	keyConfig  bootstrap.KeyConfig
+
+	// Cache flags
+	cacheDir    string
+	cacheMinTTL time.Duration
+
+	// Vault generic flags
+	vaultAddr   string
+	vaultAuth   token.AuthProvider
+	revokeToken bool
+
+	// Vault PKI flags
+	pkiMount string
+	pkiRole  string
+	pkiTTL   string
+)
+
+var Cmd = &cobra.Command{
+	Use:   "credential",
+	Short: "exec credential plugin issuing client certificates using vault",
+	Args:  cobra.NoArgs,
+	Long: `Print an ExecCredential with a short lived client certificate from vault.
+
+  This is intended to be used as the exec credential plugin of a kubeconfig
+  user, so that no long lived certificates are written to disk:
+
+    users:
+    - name: vault
+      user:
+        exec:
+          apiVersion: client.authentication.k8s.io/v1beta1
+          command: k8s-vault-csr
+          args: ["credential", "--common-name=jane", "--vault-pki-role=users"]
+
+  The certificate is issued for --common-name using the issue or sign mode,
+  so the groups are set by the vault role. Credentials are cached in
+  --cache-dir until they are within --cache-min-ttl of expiring.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := validate(); err != nil {
+			glog.Exitf("invalid flags: %s", err)
+		}
+
+		cred, err := run()
+		if err != nil {
+			glog.Exit(err)
+		}
+
+		if err := json.NewEncoder(os.Stdout).Encode(cred); err != nil {
+			glog.Exitf("write credential: %s", err)
+		}
+	},
+}
+
+// validate checks the flags required to issue a credential are set
+func validate() error {
+	if commonName == "" {
+		return errors.New("--common-name is required")
+	}
+
+	if mode != bootstrap.ModeIssue && mode != bootstrap.ModeSign {
+		return errors.Errorf("unknown mode %q, must be one of %s|%s", mode, bootstrap.ModeIssue, bootstrap.ModeSign)
+	}
+
+	if pkiRole == "" {
+		return errors.New("--vault-pki-role is required")
+	}
+
+	if !strings.HasPrefix(apiVersion, "client.authentication.k8s.io/") {
+		return errors.Errorf("unsupported --api-version %q", apiVersion)
+	}
+
+	if mode == bootstrap.ModeIssue && keyConfig != (bootstrap.KeyConfig{}) {
+		return errors.Errorf("--key-algorithm and --key-size cannot be used with --mode=%s, the key type and size are set by the vault role", mode)
+	}
+
+	if _, err := keyConfig.Complete(); err != nil {
+		return errors.Wrap(err, "invalid --key-algorithm or --key-size")
+	}
+
+	return nil
+}
+
+// cachePath is the cache file for the credential, keyed on everything that
+// changes the issued certificate and on who it was issued to
+func cachePath() string {
+	hash := sha256.Sum256([]byte(strings.Join([]string{
+		vaultAddr, pkiMount, pkiRole, pkiTTL, commonName, mode,
+		keyConfig.Algorithm, strconv.Itoa(keyConfig.Size), authIdentity(vaultAuth),
+	}, "\x00")))
+
+	return filepath.Join(cacheDir, hex.EncodeToString(hash[:])+".json")
+}
+
+// authIdentity describes who the auth provider logs in as, so credentials
+// issued to another vault identity are not reused. Secrets are left out apart
+// from a VAULT_TOKEN, which only ends up in the hash of the cache path.
+func authIdentity(provider token.AuthProvider) string {
+	switch p := provider.(type) {
+	case nil:
+		return ""
+	case *token.AuthProviderChain:
+		identities := make([]string, len(p.Providers))
+		for i, provider := range p.Providers {
+			identities[i] = authIdentity(provider)
+		}
+
+		return strings.Join(identities, ",")
+	case *token.AuthProviderKubernetes:
+		return strings.Join([]string{p.String(), p.Mount, p.Role, p.TokenFile, p.ServiceAccount, p.Namespace}, "/")
+	case *token.AuthProviderAppRole:
+		return strings.Join([]string{p.String(), p.Mount, p.RoleID, p.RoleIDFile}, "/")
+	case *token.AuthProviderAWSIAM:
+		return strings.Join([]string{p.String(), p.Mount, p.Role, p.CredentialsFile, p.Profile}, "/")
+	case *token.AuthProviderGCP:
+		return strings.Join([]string{p.String(), p.Mount, p.Role, p.ServiceAccount}, "/")
+	case *token.AuthProviderAzure:
+		return strings.Join([]string{p.String(), p.Mount, p.Role}, "/")
+	case *token.AuthProviderUserpass:
+		return strings.Join([]string{p.String(), p.Mount, p.Username}, "/")
+	case *token.AuthProviderLDAP:
+		return strings.Join([]string{p.String(), p.Mount, p.Username}, "/")
+	case *token.AuthProviderToken:
+		if p.File == "" {
+			return strings.Join([]string{p.String(), os.Getenv(api.EnvVaultToken)}, "/")
+		}
+
+		return strings.Join([]string{p.String(), p.File}, "/")
+	}
+
+	return provider.String()
+}
+
+func run() (*execCredential, error) {
+	if cacheDir != "" {
+		cred, err := loadCachedCredential(cachePath(), apiVersion, cacheMinTTL)
+		if err != nil {
+			glog.Warningf("load cached credential: %s", err)
+		} else if cred != nil {
+			return cred, nil
+		}
+	}
+
+	client, err := api.NewClient(&api.Config{
+		Address:    vaultAddr,
+		MaxRetries: 10,
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "create vault client")
+	}
+
+	renewer := token.NewRenewer(client, vaultAuth)
+	err = renewer.RunOnce()
+
+	if err != nil {
+		return nil, errors.Wrap(err, "renew vault token")
+	}
+
+	identity := bootstrap.Identity{CommonName: commonName}
+	key, cert, _, err := bootstrap.CreateCert(client, mode, pkiMount, pkiRole, pkiTTL, identity, keyConfig)
+
+	// the token is no longer needed once the certificate is issued
+	if revokeToken {
+		if err := renewer.RevokeSelf(); err != nil {
+			glog.Warningf("revoke vault token: %s", err)
+		}
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "generate client certificate")
+	}
+
+	cred, err := newExecCredential(apiVersion, key, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheDir != "" {
+		if err := os.MkdirAll(cacheDir, 0700); err != nil {
+			glog.Warningf("create credential cache: %s", err)
+		} else if err := saveCachedCredential(cachePath(), cred); err != nil {
+			glog.Warningf("cache credential: %s", err)
+		}
+	}
+
+	return cred, nil
+}
+
+func init() {
+	Cmd.Flags().StringVar(&commonName, "common-name", "", "user name to issue the client certificate for")
+	Cmd.Flags().StringVar(&apiVersion, "api-version", "client.authentication.k8s.io/v1beta1", "api version of the ExecCredential, must match the kubeconfig exec stanza")
+	Cmd.Flags().StringVar(&mode, "mode", bootstrap.ModeIssue, "how to create the client certificate (issue|sign)")
+	Cmd.Flags().StringVar(&cacheDir, "cache-dir", defaultCacheDir(), "directory to cache credentials in, disabled if empty")
+	Cmd.Flags().DurationVar(&cacheMinTTL, "cache-min-ttl", time.Minute, "minimum time a cached credential must have left to be reused")
+
+	util.FlagKeyConfig(&keyConfig, Cmd.Flags())
+	util.FlagVault(&vaultAddr, &revokeToken, Cmd.Flags())
+	util.FlagPKI(&pkiMount, &pkiRole, &pkiTTL, "15m", Cmd.Flags())
+	util.FlagAuthProvider(&vaultAuth, Cmd.Flags())
+}
+
+// defaultCacheDir is alongside the kubectl cache in the home directory
+func defaultCacheDir() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+
+	return filepath.Join(home, ".kube", "cache", "k8s-vault-csr")
+}
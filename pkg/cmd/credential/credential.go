@@ -0,0 +1,82 @@
+package credential
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/util"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+// execCredential is the client.authentication.k8s.io ExecCredential returned
+// to client-go. It is defined here as the vendored client-go predates client
+// certificate support in exec plugins.
+type execCredential struct {
+	Kind       string               `json:"kind"`
+	APIVersion string               `json:"apiVersion"`
+	Spec       struct{}             `json:"spec"`
+	Status     execCredentialStatus `json:"status"`
+}
+
+type execCredentialStatus struct {
+	ExpirationTimestamp   time.Time `json:"expirationTimestamp"`
+	ClientCertificateData string    `json:"clientCertificateData"`
+	ClientKeyData         string    `json:"clientKeyData"`
+}
+
+// newExecCredential creates an ExecCredential for the certificate, expiring
+// when the certificate does
+func newExecCredential(apiVersion string, key, cert []byte) (*execCredential, error) {
+	certs, err := certutil.ParseCertsPEM(cert)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing certificate")
+	}
+
+	return &execCredential{
+		Kind:       "ExecCredential",
+		APIVersion: apiVersion,
+		Status: execCredentialStatus{
+			ExpirationTimestamp:   certs[0].NotAfter.UTC(),
+			ClientCertificateData: string(cert),
+			ClientKeyData:         string(key),
+		},
+	}, nil
+}
+
+// loadCachedCredential returns the cached credential if it is valid for at
+// least minTTL, otherwise it returns nil
+func loadCachedCredential(path, apiVersion string, minTTL time.Duration) (*execCredential, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "reading credential cache")
+	}
+
+	var cred execCredential
+	if err := json.Unmarshal(data, &cred); err != nil {
+		return nil, errors.Wrap(err, "parsing credential cache")
+	}
+
+	if cred.APIVersion != apiVersion || time.Until(cred.Status.ExpirationTimestamp) < minTTL {
+		return nil, nil
+	}
+
+	return &cred, nil
+}
+
+// saveCachedCredential atomically writes the credential to the cache, it is
+// only readable by the owner as it contains the private key
+func saveCachedCredential(path string, cred *execCredential) error {
+	data, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFileAtomic(path, data, 0600, util.NoFileOwner)
+}
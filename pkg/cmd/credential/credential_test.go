@@ -0,0 +1,114 @@
+package credential
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/controller/certificate/bootstrap"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/vault/token"
+	"k8s.io/client-go/util/cert"
+)
+
+func TestCachedCredential(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := cert.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crt, err := cert.NewSelfSignedCACert(cert.Config{CommonName: "jane"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiVersion := "client.authentication.k8s.io/v1beta1"
+
+	cred, err := newExecCredential(apiVersion, cert.EncodePrivateKeyPEM(key), cert.EncodeCertPEM(crt))
+	if err != nil {
+		t.Fatalf("error creating credential: %s", err)
+	}
+
+	if cred.Kind != "ExecCredential" || !cred.Status.ExpirationTimestamp.Equal(crt.NotAfter) {
+		t.Errorf("unexpected credential %+v", cred)
+	}
+
+	path := filepath.Join(dir, "cred.json")
+
+	if cached, err := loadCachedCredential(path, apiVersion, time.Minute); err != nil || cached != nil {
+		t.Errorf("expected no cached credential before it is saved, got %v %v", cached, err)
+	}
+
+	if err := saveCachedCredential(path, cred); err != nil {
+		t.Fatalf("error saving credential: %s", err)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected cache to only be readable by the owner")
+	}
+
+	cached, err := loadCachedCredential(path, apiVersion, time.Minute)
+	if err != nil {
+		t.Fatalf("error loading credential: %s", err)
+	}
+
+	if cached == nil || cached.Status.ClientCertificateData != cred.Status.ClientCertificateData || cached.Status.ClientKeyData != cred.Status.ClientKeyData {
+		t.Errorf("expected cached credential to match the saved one")
+	}
+
+	// a credential close to expiry is not reused
+	if cached, err := loadCachedCredential(path, apiVersion, time.Until(crt.NotAfter)+time.Hour); err != nil || cached != nil {
+		t.Errorf("expected credential near expiry to not be reused, got %v %v", cached, err)
+	}
+
+	if cached, err := loadCachedCredential(path, "client.authentication.k8s.io/v1", time.Minute); err != nil || cached != nil {
+		t.Errorf("expected credential for another api version to not be reused, got %v %v", cached, err)
+	}
+}
+
+func TestCachePath(t *testing.T) {
+	reset := func() {
+		mode, keyConfig, pkiTTL, vaultAuth = bootstrap.ModeIssue, bootstrap.KeyConfig{}, "15m", nil
+	}
+
+	defer func(dir string) { cacheDir = dir }(cacheDir)
+	defer reset()
+
+	cacheDir = "/tmp/cache"
+	reset()
+
+	base := cachePath()
+	paths := map[string]string{}
+
+	for name, change := range map[string]func(){
+		"mode":          func() { mode = bootstrap.ModeSign },
+		"key algorithm": func() { keyConfig.Algorithm = bootstrap.KeyAlgorithmRSA },
+		"key size":      func() { keyConfig.Size = 4096 },
+		"ttl":           func() { pkiTTL = "1h" },
+		"auth role":     func() { vaultAuth = &token.AuthProviderKubernetes{Role: "other"} },
+		"auth username": func() { vaultAuth = &token.AuthProviderUserpass{Username: "jane"} },
+	} {
+		reset()
+		change()
+
+		path := cachePath()
+		if path == base {
+			t.Errorf("%s: expected the cache path to change", name)
+		}
+
+		for other, p := range paths {
+			if p == path {
+				t.Errorf("%s: expected a different cache path to %s", name, other)
+			}
+		}
+
+		paths[name] = path
+	}
+}
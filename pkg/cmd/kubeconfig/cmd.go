@@ -0,0 +1,209 @@
+package kubeconfig
+
+import (
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/controller/certificate/bootstrap"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/util"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/vault/token"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	// Identity flags
+	profile    string
+	user       string
+	commonName string
+	groups     []string
+	identity   bootstrap.Identity
+
+	// Certificate flags
+	mode      string
+	keyConfig bootstrap.KeyConfig
+
+	// Vault generic flags
+	vaultAddr   string
+	vaultAuth   token.AuthProvider
+	revokeToken bool
+
+	// Vault PKI flags
+	pkiMount string
+	pkiRole  string
+	pkiTTL   string
+
+	// Kubeconfig flags
+	kubeconfig  util.KubeconfigFlags
+	outputOwner string
+	fileOwner   = util.NoFileOwner
+)
+
+var Cmd = &cobra.Command{
+	Use:   "kubeconfig",
+	Short: "create kubeconfig for a kubernetes identity using vault",
+	Args:  cobra.NoArgs,
+	Long: `Create a kubeconfig with a client certificate issued by vault.
+
+  The identity is either set directly with --user or --common-name and
+  --group, or from a preset profile:
+
+  node: "system:node:<user>" in the "system:nodes" group
+  kube-proxy: "system:kube-proxy"
+  scheduler: "system:kube-scheduler"
+  controller-manager: "system:kube-controller-manager"
+  admin: "<user>" (default "admin") in the "system:masters" group
+
+  --common-name and --group override the values from the profile.
+
+  As with bootstrap, in the issue and sign modes the groups are set by the
+  vault role, so a role is needed per group and the node and admin profiles,
+  which set groups, cannot be used. The sign-verbatim mode uses the groups of
+  the identity, but requires access to the sign-verbatim endpoint.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := validate(cmd.Flags()); err != nil {
+			glog.Exitf("invalid flags: %s", err)
+		}
+
+		if err := run(); err != nil {
+			glog.Exit(err)
+		}
+	},
+}
+
+// validate resolves the identity and checks the flags required by the
+// selected mode are set
+func validate(fs *pflag.FlagSet) error {
+	if profile != "" {
+		var err error
+		identity, err = bootstrap.ProfileIdentity(profile, user)
+		if err != nil {
+			return err
+		}
+	} else {
+		identity = bootstrap.Identity{CommonName: user}
+	}
+
+	if commonName != "" {
+		identity.CommonName = commonName
+	}
+
+	if fs.Changed("group") {
+		identity.Groups = groups
+	}
+
+	if identity.CommonName == "" {
+		return errors.New("one of --profile, --user or --common-name is required")
+	}
+
+	switch mode {
+	case bootstrap.ModeIssue, bootstrap.ModeSign:
+		if pkiRole == "" {
+			return errors.Errorf("--vault-pki-role is required with --mode=%s", mode)
+		}
+
+		if fs.Changed("group") {
+			return errors.Errorf("--group can only be used with --mode=%s, the groups are set by the vault role", bootstrap.ModeSignVerbatim)
+		}
+
+		// the groups of the profile would otherwise be silently dropped
+		if len(identity.Groups) > 0 {
+			return errors.Errorf("--profile=%s sets the groups %v so can only be used with --mode=%s, the groups are set by the vault role", profile, identity.Groups, bootstrap.ModeSignVerbatim)
+		}
+	case bootstrap.ModeSignVerbatim:
+	default:
+		return errors.Errorf("unknown mode %q, must be one of %s|%s|%s", mode, bootstrap.ModeIssue, bootstrap.ModeSign, bootstrap.ModeSignVerbatim)
+	}
+
+	if mode == bootstrap.ModeIssue && keyConfig != (bootstrap.KeyConfig{}) {
+		return errors.Errorf("--key-algorithm and --key-size cannot be used with --mode=%s, the key type and size are set by the vault role", mode)
+	}
+
+	if _, err := keyConfig.Complete(); err != nil {
+		return errors.Wrap(err, "invalid --key-algorithm or --key-size")
+	}
+
+	if kubeconfig.Path == "" {
+		return errors.New("--output-kubeconfig-path is required")
+	}
+
+	if kubeconfig.Names.Cluster == "" || kubeconfig.Names.User == "" || kubeconfig.Names.Context == "" {
+		return errors.New("the kubeconfig cluster, user and context names cannot be empty")
+	}
+
+	owner, err := util.ParseFileOwner(outputOwner)
+	if err != nil {
+		return errors.Wrap(err, "invalid --output-owner")
+	}
+
+	fileOwner = owner
+
+	return nil
+}
+
+func run() error {
+	client, err := api.NewClient(&api.Config{
+		Address:    vaultAddr,
+		MaxRetries: 10,
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "create vault client")
+	}
+
+	renewer := token.NewRenewer(client, vaultAuth)
+	err = renewer.RunOnce()
+
+	if err != nil {
+		return errors.Wrap(err, "renew vault token")
+	}
+
+	key, cert, ca, err := bootstrap.CreateCert(client, mode, pkiMount, pkiRole, pkiTTL, identity, keyConfig)
+
+	// the token is no longer needed once the certificate is issued
+	if revokeToken {
+		if err := renewer.RevokeSelf(); err != nil {
+			glog.Warningf("revoke vault token: %s", err)
+		}
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "generate certificate for %s", identity.CommonName)
+	}
+
+	kubeconfigData := bootstrap.NewKubeconfig(kubeconfig.Names, kubeconfig.MasterAddr, kubeconfig.Insecure, key, cert, ca)
+
+	if kubeconfig.Merge {
+		merged, err := bootstrap.MergeKubeconfig(kubeconfig.Path, kubeconfigData, kubeconfig.SetCurrentContext)
+		if err != nil {
+			return err
+		}
+
+		kubeconfigData = *merged
+	}
+
+	// Marshal to disk
+	data, err := clientcmd.Write(kubeconfigData)
+	if err != nil {
+		return errors.Wrap(err, "marshal kubeconfig")
+	}
+
+	err = util.WriteFileAtomic(kubeconfig.Path, data, 0600, fileOwner)
+	return errors.Wrap(err, "write kubeconfig to disk")
+}
+
+func init() {
+	Cmd.Flags().StringVar(&profile, "profile", "", "preset identity to create the kubeconfig for (node|kube-proxy|scheduler|controller-manager|admin)")
+	Cmd.Flags().StringVar(&user, "user", "", "user name, or the node name with --profile=node")
+	Cmd.Flags().StringVar(&commonName, "common-name", "", "common name of the certificate, overrides --user and the profile")
+	Cmd.Flags().StringArrayVar(&groups, "group", nil, "group of the certificate, may be repeated, overrides the profile, only used with --mode=sign-verbatim")
+	Cmd.Flags().StringVar(&mode, "mode", bootstrap.ModeIssue, "how to create the certificate (issue|sign|sign-verbatim)")
+	Cmd.Flags().StringVar(&outputOwner, "output-owner", "", "numeric owner of the kubeconfig in the format uid[:gid]")
+
+	util.FlagKeyConfig(&keyConfig, Cmd.Flags())
+	util.FlagVault(&vaultAddr, &revokeToken, Cmd.Flags())
+	util.FlagPKI(&pkiMount, &pkiRole, &pkiTTL, "720h", Cmd.Flags())
+	util.FlagKubeconfig(&kubeconfig, Cmd.Flags())
+	util.FlagAuthProvider(&vaultAuth, Cmd.Flags())
+}
@@ -0,0 +1,77 @@
+package kubeconfig
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/controller/certificate/bootstrap"
+)
+
+// resetFlags resets the command flags to their defaults and sets the given
+// flags, returning the flag set so it can be validated
+func resetFlags(t *testing.T, flags map[string]string) *pflag.FlagSet {
+	Cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		f.Value.Set(f.DefValue)
+		f.Changed = false
+	})
+
+	groups = nil
+
+	for name, value := range flags {
+		if err := Cmd.Flags().Set(name, value); err != nil {
+			t.Fatalf("setting flag %s: %s", name, err)
+		}
+	}
+
+	return Cmd.Flags()
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		flags    map[string]string
+		expected bootstrap.Identity
+		valid    bool
+	}{
+		{"node", map[string]string{"profile": "node", "user": "k-a-node-s36b", "mode": "sign-verbatim"}, bootstrap.Identity{CommonName: "system:node:k-a-node-s36b", Groups: []string{"system:nodes"}}, true},
+		{"node with role", map[string]string{"profile": "node", "user": "k-a-node-s36b"}, bootstrap.Identity{}, false},
+		{"node without name", map[string]string{"profile": "node", "mode": "sign-verbatim"}, bootstrap.Identity{}, false},
+		{"scheduler", map[string]string{"profile": "scheduler"}, bootstrap.Identity{CommonName: "system:kube-scheduler"}, true},
+		{"admin common name", map[string]string{"profile": "admin", "common-name": "break-glass", "mode": "sign-verbatim"}, bootstrap.Identity{CommonName: "break-glass", Groups: []string{"system:masters"}}, true},
+		{"admin with sign", map[string]string{"profile": "admin", "mode": "sign"}, bootstrap.Identity{}, false},
+		{"user", map[string]string{"user": "jane"}, bootstrap.Identity{CommonName: "jane"}, true},
+		{"groups", map[string]string{"user": "jane", "mode": "sign-verbatim", "group": "team-a"}, bootstrap.Identity{CommonName: "jane", Groups: []string{"team-a"}}, true},
+		{"groups with role", map[string]string{"user": "jane", "group": "team-a"}, bootstrap.Identity{}, false},
+		{"no identity", map[string]string{}, bootstrap.Identity{}, false},
+		{"unknown profile", map[string]string{"profile": "etcd"}, bootstrap.Identity{}, false},
+		{"unknown mode", map[string]string{"user": "jane", "mode": "generate"}, bootstrap.Identity{}, false},
+		{"sign with key", map[string]string{"user": "jane", "mode": "sign", "key-algorithm": "rsa"}, bootstrap.Identity{CommonName: "jane"}, true},
+		{"issue with key", map[string]string{"user": "jane", "key-algorithm": "rsa"}, bootstrap.Identity{}, false},
+	}
+
+	for _, c := range cases {
+		flags := map[string]string{
+			"vault-pki-role":         "test",
+			"output-kubeconfig-path": "/tmp/kubeconfig",
+		}
+
+		for k, v := range c.flags {
+			flags[k] = v
+		}
+
+		err := validate(resetFlags(t, flags))
+		if !c.valid {
+			if err == nil {
+				t.Errorf("%s: expected flags to be invalid", c.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: expected flags to be valid but got: %s", c.name, err)
+		} else if !reflect.DeepEqual(identity, c.expected) {
+			t.Errorf("%s: expected identity %+v but got %+v", c.name, c.expected, identity)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package bootstrap
+
+import (
+	"net"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// Modes used to create certificates
+const (
+	ModeIssue        = "issue"
+	ModeSign         = "sign"
+	ModeSignVerbatim = "sign-verbatim"
+)
+
+// Profiles of well known kubernetes identities
+const (
+	ProfileNode              = "node"
+	ProfileKubeProxy         = "kube-proxy"
+	ProfileScheduler         = "scheduler"
+	ProfileControllerManager = "controller-manager"
+	ProfileAdmin             = "admin"
+)
+
+// Identity is the kubernetes user and groups a certificate is issued for
+type Identity struct {
+	CommonName string
+	Groups     []string
+
+	// Serving certificates are issued for server auth, with the DNS names
+	// and IP addresses as subject alternative names, instead of client auth
+	Serving     bool
+	DNSNames    []string
+	IPAddresses []net.IP
+}
+
+// ServingIdentity is the identity of a kubelet serving certificate
+func ServingIdentity(nodeName string, dnsNames []string, ipAddresses []net.IP) Identity {
+	identity := NodeIdentity(nodeName, "system:nodes")
+	identity.Serving = true
+	identity.DNSNames = dnsNames
+	identity.IPAddresses = ipAddresses
+	return identity
+}
+
+func (i Identity) extKeyUsage() string {
+	if i.Serving {
+		return "ServerAuth"
+	}
+
+	return "ClientAuth"
+}
+
+func (i Identity) ipSANs() string {
+	ips := make([]string, len(i.IPAddresses))
+	for n, ip := range i.IPAddresses {
+		ips[n] = ip.String()
+	}
+
+	return strings.Join(ips, ",")
+}
+
+// NodeIdentity is the identity of a kubelet, without groups the identity is
+// suitable for when the groups are set by the vault role
+func NodeIdentity(nodeName string, groups ...string) Identity {
+	identity := Identity{CommonName: "system:node:" + nodeName}
+
+	for _, group := range groups {
+		if group != "" {
+			identity.Groups = append(identity.Groups, group)
+		}
+	}
+
+	return identity
+}
+
+// ProfileIdentity returns the identity of a well known kubernetes component,
+// user is the node name for the node profile and the user name for the admin
+// profile, and is ignored otherwise
+func ProfileIdentity(profile, user string) (Identity, error) {
+	switch profile {
+	case ProfileNode:
+		if user == "" {
+			return Identity{}, errors.New("the node profile requires a node name")
+		}
+
+		return NodeIdentity(user, "system:nodes"), nil
+	case ProfileKubeProxy:
+		return Identity{CommonName: "system:kube-proxy"}, nil
+	case ProfileScheduler:
+		return Identity{CommonName: "system:kube-scheduler"}, nil
+	case ProfileControllerManager:
+		return Identity{CommonName: "system:kube-controller-manager"}, nil
+	case ProfileAdmin:
+		if user == "" {
+			user = "admin"
+		}
+
+		return Identity{CommonName: user, Groups: []string{"system:masters"}}, nil
+	}
+
+	return Identity{}, errors.Errorf("unknown profile %q, must be one of %s|%s|%s|%s|%s", profile, ProfileNode, ProfileKubeProxy, ProfileScheduler, ProfileControllerManager, ProfileAdmin)
+}
+
+// CreateCert issues a client certificate for the identity using one of the
+// issue, sign or sign-verbatim modes
+func CreateCert(client *api.Client, mode, pkiMount, pkiRole, pkiTTL string, identity Identity, keyConfig KeyConfig) (key, cert, ca []byte, err error) {
+	switch mode {
+	case ModeIssue:
+		return CreateCertWithIssue(client, pkiMount, pkiRole, pkiTTL, identity, keyConfig)
+	case ModeSign:
+		return CreateCertWithSign(client, pkiMount, pkiRole, pkiTTL, identity, keyConfig)
+	case ModeSignVerbatim:
+		return CreateCertWithSignVerbatim(client, pkiMount, pkiRole, pkiTTL, identity, keyConfig)
+	}
+
+	return nil, nil, nil, errors.Errorf("unknown mode %q, must be one of %s|%s|%s", mode, ModeIssue, ModeSign, ModeSignVerbatim)
+}
@@ -0,0 +1,50 @@
+package bootstrap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProfileIdentity(t *testing.T) {
+	cases := []struct {
+		profile  string
+		user     string
+		expected Identity
+		valid    bool
+	}{
+		{ProfileNode, "k-a-node-s36b", Identity{CommonName: "system:node:k-a-node-s36b", Groups: []string{"system:nodes"}}, true},
+		{ProfileNode, "", Identity{}, false},
+		{ProfileKubeProxy, "", Identity{CommonName: "system:kube-proxy"}, true},
+		{ProfileScheduler, "", Identity{CommonName: "system:kube-scheduler"}, true},
+		{ProfileControllerManager, "", Identity{CommonName: "system:kube-controller-manager"}, true},
+		{ProfileAdmin, "", Identity{CommonName: "admin", Groups: []string{"system:masters"}}, true},
+		{ProfileAdmin, "jane", Identity{CommonName: "jane", Groups: []string{"system:masters"}}, true},
+		{"etcd", "", Identity{}, false},
+	}
+
+	for _, c := range cases {
+		identity, err := ProfileIdentity(c.profile, c.user)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("%s: expected error", c.profile)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.profile, err)
+		} else if !reflect.DeepEqual(identity, c.expected) {
+			t.Errorf("%s: expected %+v but got %+v", c.profile, c.expected, identity)
+		}
+	}
+}
+
+func TestNodeIdentity(t *testing.T) {
+	if identity := NodeIdentity("k-a-node-s36b"); identity.CommonName != "system:node:k-a-node-s36b" || identity.Groups != nil {
+		t.Errorf("unexpected identity without groups: %+v", identity)
+	}
+
+	if identity := NodeIdentity("k-a-node-s36b", "system:nodes", ""); !reflect.DeepEqual(identity.Groups, []string{"system:nodes"}) {
+		t.Errorf("expected empty groups to be dropped but got: %v", identity.Groups)
+	}
+}
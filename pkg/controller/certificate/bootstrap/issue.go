@@ -1,28 +1,55 @@
 package bootstrap
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/pem"
 	"fmt"
+	"strings"
 
 	"github.com/hashicorp/vault/api"
 	"github.com/pkg/errors"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/vault/pki"
 	certutil "k8s.io/client-go/util/cert"
 )
 
+// ErrIssueKeyConfig is returned when a key config is used to issue a certificate, the key is generated by Vault
+// using the key type and size of the role
+var ErrIssueKeyConfig = errors.New("the key type and size cannot be set when vault issues the key, they are set by the role")
+
 // CreateBootstrapCertWithIssue issues a bootstrap certificate using Vault to issue the certificate and private key
-// This removes control over group name, but is more secure
-func CreateBootstrapCertWithIssue(client *api.Client, pkiMount, pkiRole, pkiTTL, nodeName string) (key, cert, ca []byte, err error) {
+// This removes control over group name, but is more secure. Vault uses the key type and size of the role, see
+// CreateCertWithIssue.
+func CreateBootstrapCertWithIssue(client *api.Client, pkiMount, pkiRole, pkiTTL, nodeName string, keyConfig KeyConfig) (key, cert, ca []byte, err error) {
+	return CreateCertWithIssue(client, pkiMount, pkiRole, pkiTTL, NodeIdentity(nodeName), keyConfig)
+}
+
+// CreateBootstrapCertWithSign issues a bootstrap certificate by generating the private key locally and having Vault
+// sign a CSR using a role. The private key never leaves the node and the role still controls the group name.
+func CreateBootstrapCertWithSign(client *api.Client, pkiMount, pkiRole, pkiTTL, nodeName string, keyConfig KeyConfig) (key, cert, ca []byte, err error) {
+	return CreateCertWithSign(client, pkiMount, pkiRole, pkiTTL, NodeIdentity(nodeName), keyConfig)
+}
+
+// CreateBootstrapCertWithSignVerbatim issues a bootstrap certificate using Vault to sign a CSR verbatim.
+// This gives control over the group name, but at the cost that the application technically has permission to issue any certificate.
+func CreateBootstrapCertWithSignVerbatim(client *api.Client, pkiMount, pkiRole, pkiTTL, nodeName, group string, keyConfig KeyConfig) (key, cert, ca []byte, err error) {
+	return CreateCertWithSignVerbatim(client, pkiMount, pkiRole, pkiTTL, NodeIdentity(nodeName, group), keyConfig)
+}
+
+// CreateCertWithIssue issues a certificate for the identity using Vault to issue the certificate and private key.
+// The groups of the identity are ignored, they are set by the role. The key type and size are also set by the role,
+// so ErrIssueKeyConfig is returned before anything is issued if the key config is not empty.
+func CreateCertWithIssue(client *api.Client, pkiMount, pkiRole, pkiTTL string, identity Identity, keyConfig KeyConfig) (key, cert, ca []byte, err error) {
+	if keyConfig != (KeyConfig{}) {
+		return nil, nil, nil, ErrIssueKeyConfig
+	}
+
 	secret, err := client.Logical().Write(
 		fmt.Sprintf("%s/issue/%s", pkiMount, pkiRole),
 		map[string]interface{}{
-			"common_name":          fmt.Sprintf("system:node:%s", nodeName),
+			"common_name":          identity.CommonName,
+			"alt_names":            strings.Join(identity.DNSNames, ","),
+			"ip_sans":              identity.ipSANs(),
 			"exclude_cn_from_sans": true,
-			"ttl": pkiTTL,
+			"ttl":                  pkiTTL,
 		},
 	)
 
@@ -30,28 +57,74 @@ func CreateBootstrapCertWithIssue(client *api.Client, pkiMount, pkiRole, pkiTTL,
 		return nil, nil, nil, err
 	}
 
-	cert = []byte(secret.Data["certificate"].(string) + "\n")
+	response, err := pki.ParseResponse(secret)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if len(response.PrivateKeyPEM) == 0 {
+		return nil, nil, nil, &pki.FieldError{Field: "private_key", Reason: "missing"}
+	}
+
+	// the groups are set by the role
+	subject := identity
+	subject.Groups = nil
+
+	cert, ca, err = verifyResponse(response, response.PrivateKeyPEM, subject)
+	return response.PrivateKeyPEM, cert, ca, err
+}
 
-	if _, ok := secret.Data["ca_chain"]; ok {
-		ca = []byte(secret.Data["ca_chain"].(string) + "\n")
+// CreateCertWithSign issues a certificate for the identity by generating the private key locally and having Vault
+// sign a CSR using a role. The groups of the identity are ignored, they are set by the role.
+func CreateCertWithSign(client *api.Client, pkiMount, pkiRole, pkiTTL string, identity Identity, keyConfig KeyConfig) (key, cert, ca []byte, err error) {
+	key, err = generateKey(keyConfig)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "generate key")
 	}
 
-	if len(ca) == 1 {
-		ca = []byte(secret.Data["issuing_ca"].(string) + "\n")
+	// the groups are set by the role
+	subject := identity
+	subject.Groups = nil
+
+	csr, err := createCSR(key, subject)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "generate csr")
 	}
 
-	return
+	secret, err := client.Logical().Write(
+		fmt.Sprintf("%s/sign/%s", pkiMount, pkiRole),
+		map[string]interface{}{
+			"csr":                  string(csr),
+			"common_name":          identity.CommonName,
+			"alt_names":            strings.Join(identity.DNSNames, ","),
+			"ip_sans":              identity.ipSANs(),
+			"exclude_cn_from_sans": true,
+			"ttl":                  pkiTTL,
+		},
+	)
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	response, err := pki.ParseResponse(secret)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cert, ca, err = verifyResponse(response, key, subject)
+	return key, cert, ca, err
 }
 
-// CreateBootstrapCertWithSignVerbatim issues a bootstrap certificate using Vault to sign a CSR verbatim.
-// This gives control over the group name, but at the cost that the application technically has permission to issue any certificate.
-func CreateBootstrapCertWithSignVerbatim(client *api.Client, pkiMount, pkiRole, pkiTTL, nodeName, group string) (key, cert, ca []byte, err error) {
-	key, err = generateECKey()
+// CreateCertWithSignVerbatim issues a certificate for the identity using Vault to sign a CSR verbatim.
+// This gives control over the groups, but at the cost that the application technically has permission to issue any certificate.
+func CreateCertWithSignVerbatim(client *api.Client, pkiMount, pkiRole, pkiTTL string, identity Identity, keyConfig KeyConfig) (key, cert, ca []byte, err error) {
+	key, err = generateKey(keyConfig)
 	if err != nil {
 		return nil, nil, nil, errors.Wrap(err, "generate key")
 	}
 
-	csr, err := createBootstrapCSR(key, nodeName, group)
+	csr, err := createCSR(key, identity)
 	if err != nil {
 		return nil, nil, nil, errors.Wrap(err, "generate csr")
 	}
@@ -61,7 +134,7 @@ func CreateBootstrapCertWithSignVerbatim(client *api.Client, pkiMount, pkiRole,
 		map[string]interface{}{
 			"csr":           string(csr),
 			"key_usage":     []string{"DigitalSignature", "KeyEncipherment"},
-			"ext_key_usage": []string{"ClientAuth"},
+			"ext_key_usage": []string{identity.extKeyUsage()},
 			"ttl":           pkiTTL,
 		},
 	)
@@ -70,42 +143,44 @@ func CreateBootstrapCertWithSignVerbatim(client *api.Client, pkiMount, pkiRole,
 		return nil, nil, nil, err
 	}
 
-	cert = []byte(secret.Data["certificate"].(string) + "\n")
-
-	if _, ok := secret.Data["ca_chain"]; ok {
-		ca = []byte(secret.Data["ca_chain"].(string) + "\n")
-	}
-
-	if len(ca) == 1 {
-		ca = []byte(secret.Data["issuing_ca"].(string) + "\n")
+	response, err := pki.ParseResponse(secret)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	return
+	cert, ca, err = verifyResponse(response, key, identity)
+	return key, cert, ca, err
 }
 
-func generateECKey() ([]byte, error) {
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return nil, errors.Wrap(err, "generate ECDSA key")
+// verifyResponse checks the certificate was issued for the key and identity,
+// the groups are only checked when set as they are otherwise set by the role
+func verifyResponse(response *pki.Certificate, key []byte, identity Identity) (cert, ca []byte, err error) {
+	if err := response.VerifyPrivateKey(key); err != nil {
+		return nil, nil, err
 	}
 
-	keyDer, err := x509.MarshalECPrivateKey(key)
-	if err != nil {
-		return nil, errors.Wrap(err, "serialize ECDSA key")
+	groups := response.Certificate.Subject.Organization
+	if len(identity.Groups) > 0 {
+		groups = identity.Groups
+	}
+
+	if err := response.VerifySubject(identity.CommonName, groups); err != nil {
+		return nil, nil, err
 	}
 
-	keyBlock := pem.Block{
-		Type:  "EC PRIVATE KEY",
-		Bytes: keyDer,
+	if identity.Serving {
+		if err := response.VerifyServing(identity.DNSNames, identity.IPAddresses); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	return pem.EncodeToMemory(&keyBlock), nil
+	return response.CertificatePEM, response.CAPEM, nil
 }
 
-func createBootstrapCSR(privateKeyData []byte, nodeName, group string) (csrData []byte, err error) {
+func createCSR(privateKeyData []byte, identity Identity) (csrData []byte, err error) {
 	subject := &pkix.Name{
-		Organization: []string{group},
-		CommonName:   "system:node:" + nodeName,
+		CommonName:   identity.CommonName,
+		Organization: identity.Groups,
 	}
 
 	privateKey, err := certutil.ParsePrivateKeyPEM(privateKeyData)
@@ -113,7 +188,7 @@ func createBootstrapCSR(privateKeyData []byte, nodeName, group string) (csrData
 		return nil, errors.Wrap(err, "invalid private key for certificate request")
 	}
 
-	csrData, err = certutil.MakeCSR(privateKey, subject, nil, nil)
+	csrData, err = certutil.MakeCSR(privateKey, subject, identity.DNSNames, identity.IPAddresses)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to generate certificate request")
 	}
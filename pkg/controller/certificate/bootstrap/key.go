@@ -0,0 +1,128 @@
+package bootstrap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+)
+
+// Key algorithms
+const (
+	KeyAlgorithmECDSA   = "ecdsa"
+	KeyAlgorithmRSA     = "rsa"
+	KeyAlgorithmEd25519 = "ed25519"
+)
+
+// KeyConfig configures the private key of a bootstrap certificate. In issue
+// mode vault generates the key using the key type of the role, so the config
+// must be empty.
+type KeyConfig struct {
+	// Algorithm is one of ecdsa or rsa, defaults to ecdsa
+	Algorithm string
+
+	// Size is the curve size for ecdsa (256|384|521) or the modulus size for
+	// rsa (2048|3072|4096), zero uses the default of the algorithm
+	Size int
+}
+
+// DefaultKeyConfig is an ECDSA P-256 key
+var DefaultKeyConfig = KeyConfig{Algorithm: KeyAlgorithmECDSA, Size: 256}
+
+// Complete fills in defaults and checks the algorithm and size are supported
+func (k KeyConfig) Complete() (KeyConfig, error) {
+	switch k.Algorithm {
+	case KeyAlgorithmECDSA, "":
+		k.Algorithm = KeyAlgorithmECDSA
+
+		if k.Size == 0 {
+			k.Size = 256
+		}
+
+		if _, err := curve(k.Size); err != nil {
+			return k, err
+		}
+	case KeyAlgorithmRSA:
+		if k.Size == 0 {
+			k.Size = 2048
+		}
+
+		if k.Size != 2048 && k.Size != 3072 && k.Size != 4096 {
+			return k, errors.Errorf("unsupported rsa key size %d, must be one of 2048|3072|4096", k.Size)
+		}
+	case KeyAlgorithmEd25519:
+		// Neither kubernetes client certificates, vault pki or the x509
+		// package this is built with can handle ed25519 keys yet
+		return k, errors.New("ed25519 keys are not supported by kubernetes or vault pki")
+	default:
+		return k, errors.Errorf("unknown key algorithm %q, must be one of %s|%s", k.Algorithm, KeyAlgorithmECDSA, KeyAlgorithmRSA)
+	}
+
+	return k, nil
+}
+
+func curve(size int) (elliptic.Curve, error) {
+	switch size {
+	case 256:
+		return elliptic.P256(), nil
+	case 384:
+		return elliptic.P384(), nil
+	case 521:
+		return elliptic.P521(), nil
+	}
+
+	return nil, errors.Errorf("unsupported ecdsa key size %d, must be one of 256|384|521", size)
+}
+
+// generateKey generates a PEM encoded private key, ecdsa keys are SEC1
+// encoded and rsa keys are PKCS#1 encoded
+func generateKey(config KeyConfig) ([]byte, error) {
+	config, err := config.Complete()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Algorithm == KeyAlgorithmRSA {
+		return generateRSAKey(config.Size)
+	}
+
+	c, _ := curve(config.Size)
+	return generateECKey(c)
+}
+
+func generateECKey(c elliptic.Curve) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(c, rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate ECDSA key")
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "serialize ECDSA key")
+	}
+
+	keyBlock := pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: keyDer,
+	}
+
+	return pem.EncodeToMemory(&keyBlock), nil
+}
+
+func generateRSAKey(bits int) ([]byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate RSA key")
+	}
+
+	keyBlock := pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}
+
+	return pem.EncodeToMemory(&keyBlock), nil
+}
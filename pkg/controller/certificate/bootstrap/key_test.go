@@ -0,0 +1,73 @@
+package bootstrap
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/pem"
+	"testing"
+
+	"k8s.io/client-go/util/cert"
+)
+
+func TestGenerateKey(t *testing.T) {
+	cases := []struct {
+		config   KeyConfig
+		pemType  string
+		keyBits  int
+		keyError bool
+	}{
+		{KeyConfig{}, "EC PRIVATE KEY", 256, false},
+		{KeyConfig{Algorithm: KeyAlgorithmECDSA, Size: 384}, "EC PRIVATE KEY", 384, false},
+		{KeyConfig{Algorithm: KeyAlgorithmECDSA, Size: 521}, "EC PRIVATE KEY", 521, false},
+		{KeyConfig{Algorithm: KeyAlgorithmECDSA, Size: 2048}, "", 0, true},
+		{KeyConfig{Algorithm: KeyAlgorithmRSA}, "RSA PRIVATE KEY", 2048, false},
+		{KeyConfig{Algorithm: KeyAlgorithmRSA, Size: 3072}, "RSA PRIVATE KEY", 3072, false},
+		{KeyConfig{Algorithm: KeyAlgorithmRSA, Size: 1024}, "", 0, true},
+		{KeyConfig{Algorithm: KeyAlgorithmEd25519}, "", 0, true},
+		{KeyConfig{Algorithm: "dsa"}, "", 0, true},
+	}
+
+	for _, c := range cases {
+		keyData, err := generateKey(c.config)
+		if c.keyError {
+			if err == nil {
+				t.Errorf("%+v: expected error", c.config)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%+v: failed to generate key: %s", c.config, err)
+			continue
+		}
+
+		block, _ := pem.Decode(keyData)
+		if block == nil || block.Type != c.pemType {
+			t.Errorf("%+v: expected pem block of type %s", c.config, c.pemType)
+			continue
+		}
+
+		key, err := cert.ParsePrivateKeyPEM(keyData)
+		if err != nil {
+			t.Errorf("%+v: failed to parse key: %s", c.config, err)
+			continue
+		}
+
+		var bits int
+		switch k := key.(type) {
+		case *ecdsa.PrivateKey:
+			bits = k.Curve.Params().BitSize
+		case *rsa.PrivateKey:
+			bits = k.N.BitLen()
+		}
+
+		if bits != c.keyBits {
+			t.Errorf("%+v: expected %d bit key but got %d", c.config, c.keyBits, bits)
+		}
+
+		// the key must be usable to create a certificate request
+		if _, err := createCSR(keyData, NodeIdentity("k-a-node-s36b")); err != nil {
+			t.Errorf("%+v: failed to create csr: %s", c.config, err)
+		}
+	}
+}
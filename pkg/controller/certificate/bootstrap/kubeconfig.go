@@ -0,0 +1,84 @@
+package bootstrap
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// KubeconfigNames are the names of the cluster, user and context entries in a
+// kubeconfig, and the namespace of the context
+type KubeconfigNames struct {
+	Cluster   string
+	User      string
+	Context   string
+	Namespace string
+}
+
+// DefaultKubeconfigNames are the names used when none are configured
+var DefaultKubeconfigNames = KubeconfigNames{
+	Cluster:   "default-cluster",
+	User:      "default-auth",
+	Context:   "default-context",
+	Namespace: "default",
+}
+
+// NewKubeconfig creates a kubeconfig that authenticates to the apiserver at
+// server using a client certificate
+func NewKubeconfig(names KubeconfigNames, server string, insecure bool, key, cert, ca []byte) clientcmdapi.Config {
+	return clientcmdapi.Config{
+		// Define a cluster stanza based on the bootstrap kubeconfig.
+		Clusters: map[string]*clientcmdapi.Cluster{names.Cluster: {
+			Server:                   server,
+			InsecureSkipTLSVerify:    insecure,
+			CertificateAuthorityData: ca,
+		}},
+		// Define auth based on the obtained client cert.
+		AuthInfos: map[string]*clientcmdapi.AuthInfo{names.User: {
+			ClientCertificateData: cert,
+			ClientKeyData:         key,
+		}},
+		// Define a context that connects the auth info and cluster, and set it as the default
+		Contexts: map[string]*clientcmdapi.Context{names.Context: {
+			Cluster:   names.Cluster,
+			AuthInfo:  names.User,
+			Namespace: names.Namespace,
+		}},
+		CurrentContext: names.Context,
+	}
+}
+
+// MergeKubeconfig adds or replaces the clusters, users and contexts of src in
+// the kubeconfig at path, keeping all other entries. The current context is
+// only switched to the one from src if setCurrentContext is true or there is
+// no current context. If there is no kubeconfig at path src is returned.
+func MergeKubeconfig(path string, src clientcmdapi.Config, setCurrentContext bool) (*clientcmdapi.Config, error) {
+	dst, err := clientcmd.LoadFromFile(path)
+	if os.IsNotExist(err) {
+		return &src, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "load existing kubeconfig")
+	}
+
+	for name, cluster := range src.Clusters {
+		dst.Clusters[name] = cluster
+	}
+
+	for name, authInfo := range src.AuthInfos {
+		dst.AuthInfos[name] = authInfo
+	}
+
+	for name, context := range src.Contexts {
+		dst.Contexts[name] = context
+	}
+
+	if setCurrentContext || dst.CurrentContext == "" {
+		dst.CurrentContext = src.CurrentContext
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,77 @@
+package bootstrap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestMergeKubeconfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "kubeconfig")
+
+	names := KubeconfigNames{Cluster: "vault", User: "vault-node", Context: "vault-node", Namespace: "kube-system"}
+	src := NewKubeconfig(names, "https://apiserver:6443", false, []byte("key"), []byte("cert"), []byte("ca"))
+
+	// without an existing kubeconfig the new one is used as is
+	merged, err := MergeKubeconfig(path, src, false)
+	if err != nil {
+		t.Fatalf("error merging into missing kubeconfig: %s", err)
+	}
+
+	if merged.CurrentContext != "vault-node" || len(merged.Contexts) != 1 {
+		t.Errorf("expected the new kubeconfig but got %+v", merged)
+	}
+
+	existing := clientcmdapi.NewConfig()
+	existing.Clusters["other"] = &clientcmdapi.Cluster{Server: "https://other:6443"}
+	existing.Clusters["vault"] = &clientcmdapi.Cluster{Server: "https://old:6443"}
+	existing.AuthInfos["other"] = &clientcmdapi.AuthInfo{Token: "token"}
+	existing.Contexts["other"] = &clientcmdapi.Context{Cluster: "other", AuthInfo: "other"}
+	existing.CurrentContext = "other"
+
+	if err := clientcmd.WriteToFile(*existing, path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, setCurrentContext := range []bool{false, true} {
+		merged, err := MergeKubeconfig(path, src, setCurrentContext)
+		if err != nil {
+			t.Fatalf("error merging kubeconfig: %s", err)
+		}
+
+		if merged.Clusters["other"] == nil || merged.AuthInfos["other"] == nil || merged.Contexts["other"] == nil {
+			t.Errorf("expected existing entries to be kept")
+		}
+
+		if cluster := merged.Clusters["vault"]; cluster == nil || cluster.Server != "https://apiserver:6443" {
+			t.Errorf("expected cluster to be replaced but got %+v", cluster)
+		}
+
+		if authInfo := merged.AuthInfos["vault-node"]; authInfo == nil || string(authInfo.ClientCertificateData) != "cert" {
+			t.Errorf("expected user to be added but got %+v", authInfo)
+		}
+
+		if context := merged.Contexts["vault-node"]; context == nil || context.Cluster != "vault" || context.Namespace != "kube-system" {
+			t.Errorf("expected context to be added but got %+v", context)
+		}
+
+		expected := "other"
+		if setCurrentContext {
+			expected = "vault-node"
+		}
+
+		if merged.CurrentContext != expected {
+			t.Errorf("expected current context %q but got %q", expected, merged.CurrentContext)
+		}
+	}
+}
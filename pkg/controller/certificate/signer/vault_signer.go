@@ -2,16 +2,29 @@ package signer
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	vaultAPI "github.com/hashicorp/vault/api"
 	"github.com/pkg/errors"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/vault/pki"
 	capi "k8s.io/api/certificates/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
 	certificatesinformers "k8s.io/client-go/informers/certificates/v1beta1"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/kubernetes/pkg/controller/certificates"
 )
 
+// signedCacheSize is the maximum number of signed certificates kept for reuse
+const signedCacheSize = 1024
+
+// signedCacheTTL is how long a signed certificate is kept for reuse when
+// writing it to the status of its csr is retried
+const signedCacheTTL = 10 * time.Minute
+
 // KeyUsage contains a mapping of string names to key usages.
 var keyUsageLookup = map[capi.KeyUsage]string{
 	"signing":            "DigitalSignature",
@@ -44,6 +57,43 @@ var extKeyUsageLookup = map[capi.KeyUsage]string{
 	"netscape sgc":     "NetscapeServerGatedCrypto",
 }
 
+// Options configures the certificates written by the vault signer
+type Options struct {
+	// IncludeCAChain appends the ca chain returned by vault to the issued
+	// certificate, so clients can build a chain to the root when vault signs
+	// from an intermediate
+	IncludeCAChain bool
+
+	// ExcludeRootCA leaves the self signed root out of the appended chain
+	ExcludeRootCA bool
+
+	// HealthGate pauses signing while vault is unhealthy, nil never pauses
+	HealthGate HealthGate
+}
+
+// HealthGate blocks signing until vault is able to sign
+type HealthGate interface {
+	// Wait blocks until vault is healthy, it returns false if vault will
+	// never become healthy, such as during shutdown
+	Wait() bool
+}
+
+// VaultSigningController is a certificate signing controller that waits for
+// in-flight signing requests when it is stopped
+type VaultSigningController struct {
+	*certificates.CertificateController
+
+	signer *vaultSigner
+}
+
+// Run starts the workers and blocks until the stop channel is closed and
+// every in-flight signing request has returned, so the vault token can be
+// safely revoked afterwards
+func (c *VaultSigningController) Run(workers int, stopCh <-chan struct{}) {
+	c.CertificateController.Run(workers, stopCh)
+	c.signer.stop()
+}
+
 // NewVaultSigningController creates a certificate signing controller that
 // uses vault to sign certificates. It uses the `sign verbatim` functionality
 // of vault to achieve this.
@@ -53,20 +103,36 @@ func NewVaultSigningController(
 	vclient *vaultAPI.Client,
 	mount string,
 	role string,
-) (*certificates.CertificateController, error) {
-	return certificates.NewCertificateController(
-		kclient,
-		csrInformer,
-		newVaultSigner(kclient, vclient, mount, role).handle,
-	), nil
+	options Options,
+) (*VaultSigningController, error) {
+	signer := newVaultSigner(kclient, vclient, mount, role, options)
+
+	return &VaultSigningController{
+		CertificateController: certificates.NewCertificateController(
+			kclient,
+			csrInformer,
+			signer.handle,
+		),
+		signer: signer,
+	}, nil
 }
 
 type vaultSigner struct {
 	kclient clientset.Interface
 	vclient *vaultAPI.Client
 
-	mount string
-	role  string
+	mount   string
+	role    string
+	options Options
+
+	// signed caches certificates by csr uid and resource version, so a
+	// failed status write is retried without signing again
+	signed *cache.LRUExpireCache
+
+	// inflight tracks running handlers, no handlers start once stopped
+	lock     sync.Mutex
+	stopped  bool
+	inflight sync.WaitGroup
 }
 
 func newVaultSigner(
@@ -74,31 +140,129 @@ func newVaultSigner(
 	vclient *vaultAPI.Client,
 	mount string,
 	role string,
+	options Options,
 ) *vaultSigner {
 	return &vaultSigner{
 		kclient: kclient,
 		vclient: vclient,
 		mount:   mount,
 		role:    role,
+		options: options,
+		signed:  cache.NewLRUExpireCache(signedCacheSize),
 	}
 }
 
 func (s *vaultSigner) handle(csr *capi.CertificateSigningRequest) error {
+	if !s.begin() {
+		return errors.New("handling signing request: signer stopped")
+	}
+	defer s.inflight.Done()
+
 	if !certificates.IsCertificateRequestApproved(csr) {
 		return nil
 	}
 
-	glog.V(1).Infof("signing csr using vault namespace=%s name=%s", csr.ObjectMeta.Namespace, csr.ObjectMeta.Name)
+	// signing again would mint a second certificate for the request
+	if len(csr.Status.Certificate) > 0 {
+		return nil
+	}
+
+	certificate, ok := s.cachedCertificate(csr)
+	if ok {
+		glog.V(1).Infof("reusing signed certificate for csr namespace=%s name=%s", csr.ObjectMeta.Namespace, csr.ObjectMeta.Name)
+	} else {
+		// blocking the worker stops it dequeuing, so csrs stay pending
+		// until vault is healthy instead of failing and being requeued
+		if s.options.HealthGate != nil && !s.options.HealthGate.Wait() {
+			return errors.New("handling signing request: stopped waiting for vault to become healthy")
+		}
 
-	csr, err := s.sign(csr)
-	if err != nil {
-		return errors.Wrap(err, "handling signing request")
+		glog.V(1).Infof("signing csr using vault namespace=%s name=%s", csr.ObjectMeta.Namespace, csr.ObjectMeta.Name)
+
+		signed, err := s.sign(csr)
+		if err != nil {
+			return errors.Wrap(err, "handling signing request")
+		}
+
+		certificate = signed.Status.Certificate
 	}
 
-	_, err = s.kclient.CertificatesV1beta1().CertificateSigningRequests().UpdateStatus(csr)
+	err := s.updateStatus(csr, certificate)
 	return errors.Wrap(err, "handling signing request: updating signature for csr")
 }
 
+// begin registers a running handler, it returns false once the signer is
+// stopped
+func (s *vaultSigner) begin() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped {
+		return false
+	}
+
+	s.inflight.Add(1)
+	return true
+}
+
+// stop prevents new handlers from starting and waits for running handlers
+// to return
+func (s *vaultSigner) stop() {
+	s.lock.Lock()
+	s.stopped = true
+	s.lock.Unlock()
+
+	s.inflight.Wait()
+}
+
+// updateStatus writes the certificate to the status of the csr. On conflict
+// the csr is refetched and the write retried with the same certificate,
+// unless another writer has already set a certificate.
+func (s *vaultSigner) updateStatus(csr *capi.CertificateSigningRequest, certificate []byte) error {
+	csrs := s.kclient.CertificatesV1beta1().CertificateSigningRequests()
+	refetch := false
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if refetch {
+			latest, err := csrs.Get(csr.ObjectMeta.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			if latest.ObjectMeta.UID != csr.ObjectMeta.UID || len(latest.Status.Certificate) > 0 || !certificates.IsCertificateRequestApproved(latest) {
+				return nil
+			}
+
+			csr = latest
+		}
+
+		refetch = true
+
+		// cache against the version being written, so a retry from the
+		// queue after a failed write reuses the certificate
+		s.signed.Add(signedCacheKey(csr), certificate, signedCacheTTL)
+
+		csr.Status.Certificate = certificate
+		_, err := csrs.UpdateStatus(csr)
+		return err
+	})
+}
+
+// cachedCertificate returns the certificate already signed for this version
+// of the csr
+func (s *vaultSigner) cachedCertificate(csr *capi.CertificateSigningRequest) ([]byte, bool) {
+	certificate, ok := s.signed.Get(signedCacheKey(csr))
+	if !ok {
+		return nil, false
+	}
+
+	return certificate.([]byte), true
+}
+
+func signedCacheKey(csr *capi.CertificateSigningRequest) string {
+	return string(csr.ObjectMeta.UID) + "/" + csr.ObjectMeta.ResourceVersion
+}
+
 func (s *vaultSigner) sign(csr *capi.CertificateSigningRequest) (*capi.CertificateSigningRequest, error) {
 	secret, err := s.vclient.Logical().Write(
 		fmt.Sprintf("%s/sign-verbatim/%s", s.mount, s.role),
@@ -113,7 +277,27 @@ func (s *vaultSigner) sign(csr *capi.CertificateSigningRequest) (*capi.Certifica
 		return nil, errors.Wrap(err, "signing with vault api")
 	}
 
-	csr.Status.Certificate = []byte(secret.Data["certificate"].(string))
+	response, err := pki.ParseResponse(secret)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing vault response")
+	}
+
+	// sign-verbatim copies the subject and key of the request
+	if err := response.VerifyRequest(csr.Spec.Request); err != nil {
+		return nil, errors.Wrap(err, "verifying vault response")
+	}
+
+	if !s.options.IncludeCAChain {
+		csr.Status.Certificate = response.CertificatePEM
+		return csr, nil
+	}
+
+	bundle, err := response.Bundle(s.options.ExcludeRootCA)
+	if err != nil {
+		return nil, errors.Wrap(err, "building certificate chain")
+	}
+
+	csr.Status.Certificate = bundle
 
 	return csr, nil
 }
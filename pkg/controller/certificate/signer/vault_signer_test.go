@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 	"reflect"
+	"time"
 	"crypto/x509"
 
 	log "github.com/hashicorp/go-hclog"
@@ -16,6 +17,12 @@ import (
 	"github.com/hashicorp/vault/builtin/logical/pki"
 	"k8s.io/client-go/util/cert"
 	capi "k8s.io/api/certificates/v1beta1"	
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 const kubeletCSR = `
@@ -128,7 +135,7 @@ func TestSigner(t *testing.T) {
 		},
 	}
 
-	signer := newVaultSigner(nil, client, "pki", "")
+	signer := newVaultSigner(nil, client, "pki", "", Options{})
 
 	csr, err = signer.sign(csr)
 	if err != nil {
@@ -161,4 +168,172 @@ func TestSigner(t *testing.T) {
 	if !reflect.DeepEqual(crt.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}) {
 		t.Errorf("bad extended key usage")
 	}
+
+	// Test ca chain, vault signs from the root so the chain is the root
+
+	chainCases := []struct {
+		options Options
+		certs   int
+		last    string
+	}{
+		{Options{IncludeCAChain: true}, 2, "Test Vault CA"},
+		{Options{IncludeCAChain: true, ExcludeRootCA: true}, 1, "system:node:k-a-node-s36b"},
+	}
+
+	for _, c := range chainCases {
+		csr.Status.Certificate = nil
+
+		csr, err = newVaultSigner(nil, client, "pki", "", c.options).sign(csr)
+		if err != nil {
+			t.Fatalf("failed to sign CSR with %+v: %v", c.options, err)
+		}
+
+		certs, err := cert.ParseCertsPEM(csr.Status.Certificate)
+		if err != nil {
+			t.Fatalf("failed to parse certificate chain: %v", err)
+		}
+		if len(certs) != c.certs {
+			t.Errorf("expected %d certificates with %+v, got %d", c.certs, c.options, len(certs))
+			continue
+		}
+		if last := certs[len(certs)-1].Subject.CommonName; last != c.last {
+			t.Errorf("expected last certificate in chain %q, got %q", c.last, last)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	approved := capi.CertificateSigningRequestStatus{
+		Conditions: []capi.CertificateSigningRequestCondition{
+			{Type: capi.CertificateApproved},
+		},
+	}
+
+	newCSR := func(resourceVersion string) *capi.CertificateSigningRequest {
+		return &capi.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "node-csr",
+				UID:             "1234",
+				ResourceVersion: resourceVersion,
+			},
+			Spec: capi.CertificateSigningRequestSpec{
+				Request: []byte(kubeletCSR),
+			},
+			Status: *approved.DeepCopy(),
+		}
+	}
+
+	// Already signed, the vault client is nil so signing would panic
+
+	signedCSR := newCSR("1")
+	signedCSR.Status.Certificate = []byte("signed")
+
+	kclient := fake.NewSimpleClientset(signedCSR)
+	signer := newVaultSigner(kclient, nil, "pki", "", Options{})
+
+	if err := signer.handle(signedCSR); err != nil {
+		t.Errorf("expected signed csr to be skipped, got: %s", err)
+	}
+	if len(kclient.Actions()) != 0 {
+		t.Errorf("expected no requests for signed csr, got %d", len(kclient.Actions()))
+	}
+
+	// Cached certificate with a conflicting status write
+
+	csr := newCSR("1")
+	latest := newCSR("2")
+
+	kclient = fake.NewSimpleClientset(latest)
+	signer = newVaultSigner(kclient, nil, "pki", "", Options{})
+	signer.signed.Add(signedCacheKey(csr), []byte("certificate"), signedCacheTTL)
+
+	updates := 0
+	kclient.PrependReactor("update", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+
+		update := action.(k8stesting.UpdateAction).GetObject().(*capi.CertificateSigningRequest)
+		if update.ResourceVersion == "1" {
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Group: "certificates.k8s.io", Resource: "certificatesigningrequests"}, update.Name, nil)
+		}
+
+		return false, nil, nil
+	})
+
+	if err := signer.handle(csr); err != nil {
+		t.Fatalf("expected conflict to be retried, got: %s", err)
+	}
+
+	if updates != 2 {
+		t.Errorf("expected 2 status updates, got %d", updates)
+	}
+
+	stored, err := kclient.CertificatesV1beta1().CertificateSigningRequests().Get("node-csr", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored.Status.Certificate) != "certificate" {
+		t.Errorf("expected cached certificate to be written, got %q", stored.Status.Certificate)
+	}
+
+	if certificate, ok := signer.cachedCertificate(latest); !ok || string(certificate) != "certificate" {
+		t.Errorf("expected certificate to be cached for the refetched csr")
+	}
+
+	// Unhealthy vault, the gate is released without vault becoming healthy
+
+	kclient = fake.NewSimpleClientset(newCSR("1"))
+	gate := &fakeHealthGate{}
+	signer = newVaultSigner(kclient, nil, "pki", "", Options{HealthGate: gate})
+
+	if err := signer.handle(newCSR("1")); err == nil {
+		t.Errorf("expected error when vault never becomes healthy")
+	}
+	if gate.waits != 1 {
+		t.Errorf("expected signing to wait for vault health")
+	}
+	if len(kclient.Actions()) != 0 {
+		t.Errorf("expected no requests while vault is unhealthy, got %d", len(kclient.Actions()))
+	}
+}
+
+// fakeHealthGate reports vault will never become healthy
+type fakeHealthGate struct {
+	waits int
+}
+
+func (g *fakeHealthGate) Wait() bool {
+	g.waits++
+	return false
+}
+
+func TestStop(t *testing.T) {
+	signer := newVaultSigner(nil, nil, "pki", "", Options{})
+
+	if !signer.begin() {
+		t.Fatal("expected handler to start before stop")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		signer.stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("expected stop to wait for the running handler")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	signer.inflight.Done()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected stop to return once the handler returned")
+	}
+
+	if err := signer.handle(&capi.CertificateSigningRequest{}); err == nil {
+		t.Errorf("expected handle to fail once stopped")
+	}
 }
@@ -0,0 +1,140 @@
+package metadata
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultAWSEndpoint is the address of the EC2 instance metadata service
+	DefaultAWSEndpoint = "http://169.254.169.254"
+
+	// DefaultGCPEndpoint is the address of the GCE metadata server
+	DefaultGCPEndpoint = "http://metadata.google.internal"
+
+	// DefaultAzureEndpoint is the address of the Azure instance metadata
+	// service
+	DefaultAzureEndpoint = "http://169.254.169.254"
+)
+
+// ErrNotFound is returned when the metadata service has no value for a path
+var ErrNotFound = errors.New("not found in metadata service")
+
+// Client reads the instance metadata service of a cloud provider. A client
+// caches the aws session token so it is not safe for concurrent use.
+type Client struct {
+	endpoint string
+	header   http.Header
+	client   *http.Client
+
+	// awsSession requests an IMDSv2 session token before the first request
+	awsSession bool
+}
+
+// NewAWS creates a client for the EC2 instance metadata service. IMDSv2 is
+// used if available, falling back to IMDSv1.
+func NewAWS(endpoint string) *Client {
+	c := newClient(endpoint, DefaultAWSEndpoint)
+	c.awsSession = true
+	return c
+}
+
+// NewGCP creates a client for the GCE metadata server
+func NewGCP(endpoint string) *Client {
+	c := newClient(endpoint, DefaultGCPEndpoint)
+	c.header.Set("Metadata-Flavor", "Google")
+	return c
+}
+
+// NewAzure creates a client for the Azure instance metadata service
+func NewAzure(endpoint string) *Client {
+	c := newClient(endpoint, DefaultAzureEndpoint)
+	c.header.Set("Metadata", "true")
+	return c
+}
+
+func newClient(endpoint, def string) *Client {
+	if endpoint == "" {
+		endpoint = def
+	}
+
+	return &Client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		header:   http.Header{},
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Get reads the value at path, which may include a query string. ErrNotFound
+// is returned if the path does not exist.
+func (c *Client) Get(path string) ([]byte, error) {
+	if c.awsSession {
+		c.startAWSSession()
+	}
+
+	req, err := http.NewRequest("GET", c.endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.Wrap(ErrNotFound, path)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %s from metadata service", resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// GetJSON reads and decodes the json document at path
+func (c *Client) GetJSON(path string, v interface{}) error {
+	data, err := c.Get(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// startAWSSession requests an IMDSv2 session token once, without a token the
+// requests are made using IMDSv1
+func (c *Client) startAWSSession() {
+	c.awsSession = false
+
+	req, err := http.NewRequest("PUT", c.endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err == nil && resp.StatusCode == http.StatusOK {
+		c.header.Set("X-aws-ec2-metadata-token", strings.TrimSpace(string(data)))
+	}
+}
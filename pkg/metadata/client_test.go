@@ -0,0 +1,113 @@
+package metadata
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestClientAWS(t *testing.T) {
+	sessions := 0
+
+	// Fake EC2 metadata service, only allowing IMDSv2 when enabled
+	imdsv2 := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			if r.Method != "PUT" || !imdsv2 {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			sessions++
+			w.Write([]byte("session-token\n"))
+			return
+		}
+
+		if imdsv2 && r.Header.Get("X-aws-ec2-metadata-token") != "session-token" {
+			http.Error(w, "missing session token", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/latest/meta-data/local-ipv4":
+			w.Write([]byte("10.0.0.10"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewAWS(server.URL + "/")
+
+	for i := 0; i < 2; i++ {
+		data, err := client.Get("/latest/meta-data/local-ipv4")
+		if err != nil {
+			t.Fatalf("error reading metadata: %s", err)
+		}
+
+		if string(data) != "10.0.0.10" {
+			t.Errorf("expected 10.0.0.10, got %q", data)
+		}
+	}
+
+	if sessions != 1 {
+		t.Errorf("expected a single session token request, got %d", sessions)
+	}
+
+	if _, err := client.Get("/latest/meta-data/public-ipv4"); errors.Cause(err) != ErrNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	// IMDSv1 is used when no session token is returned
+	imdsv2 = false
+
+	if _, err := NewAWS(server.URL).Get("/latest/meta-data/local-ipv4"); err != nil {
+		t.Errorf("expected fall back to IMDSv1, got %s", err)
+	}
+}
+
+func TestClientHeaders(t *testing.T) {
+	cases := []struct {
+		name   string
+		client func(endpoint string) *Client
+		header string
+		value  string
+	}{
+		{"gcp", NewGCP, "Metadata-Flavor", "Google"},
+		{"azure", NewAzure, "Metadata", "true"},
+	}
+
+	for _, c := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(c.header) != c.value {
+				http.Error(w, "missing header", http.StatusBadRequest)
+				return
+			}
+
+			if r.URL.Query().Get("api-version") != "2017-08-01" {
+				http.Error(w, "missing query", http.StatusBadRequest)
+				return
+			}
+
+			w.Write([]byte(`{"name": "k-a-node-s36b"}`))
+		}))
+
+		var v struct {
+			Name string `json:"name"`
+		}
+
+		if err := c.client(server.URL).GetJSON("/instance?api-version=2017-08-01", &v); err != nil {
+			t.Errorf("%s: error reading metadata: %s", c.name, err)
+		} else if v.Name != "k-a-node-s36b" {
+			t.Errorf("%s: expected name k-a-node-s36b, got %q", c.name, v.Name)
+		}
+
+		if _, err := c.client(server.URL + "/bad").Get("/"); err == nil {
+			t.Errorf("%s: expected error for bad request", c.name)
+		}
+
+		server.Close()
+	}
+}
@@ -0,0 +1,141 @@
+package node
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/metadata"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/util"
+)
+
+// Sources the node identity can be detected from
+const (
+	SourceHostname  = "hostname"
+	SourceFQDN      = "fqdn"
+	SourceAWS       = "aws"
+	SourceGCP       = "gcp"
+	SourceAzure     = "azure"
+	SourceOpenStack = "openstack"
+)
+
+// Identity is the name a node registers with kubernetes and its addresses
+type Identity struct {
+	Name        string
+	DNSNames    []string
+	IPAddresses []net.IP
+}
+
+// Detector detects the node identity from a single source, following the
+// naming rules of the matching kubelet cloud provider
+type Detector struct {
+	Source string
+
+	// MetadataEndpoint overrides the instance metadata address of the aws,
+	// gcp and azure sources
+	MetadataEndpoint string
+
+	// ConfigDrivePath is the mount path of the openstack config drive
+	ConfigDrivePath string
+}
+
+// Detect returns the identity of the node
+func (d Detector) Detect() (*Identity, error) {
+	switch d.Source {
+	case SourceHostname:
+		return d.hostname()
+	case SourceFQDN:
+		return d.fqdn()
+	case SourceAWS:
+		return d.aws()
+	case SourceGCP:
+		return d.gcp()
+	case SourceAzure:
+		return d.azure()
+	case SourceOpenStack:
+		return d.openstack()
+	}
+
+	return nil, errors.Errorf("unknown node identity source %q, must be one of %s|%s|%s|%s|%s|%s", d.Source, SourceHostname, SourceFQDN, SourceAWS, SourceGCP, SourceAzure, SourceOpenStack)
+}
+
+// hostname names the node after the lowercase hostname, as kubelet does
+// without a cloud provider
+func (d Detector) hostname() (*Identity, error) {
+	dnsNames, ips, err := util.DetectAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.ToLower(strings.TrimSpace(dnsNames[0]))
+
+	return &Identity{
+		Name:        name,
+		DNSNames:    []string{name},
+		IPAddresses: ips,
+	}, nil
+}
+
+// fqdn names the node after the canonical name of the hostname
+func (d Detector) fqdn() (*Identity, error) {
+	identity, err := d.hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	cname, err := net.LookupCNAME(identity.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "looking up fqdn of %s", identity.Name)
+	}
+
+	fqdn := strings.ToLower(strings.TrimSuffix(cname, "."))
+	if fqdn != identity.Name {
+		identity.DNSNames = append([]string{fqdn}, identity.DNSNames...)
+	}
+
+	identity.Name = fqdn
+
+	return identity, nil
+}
+
+// get reads a metadata value, returning an empty string if it does not exist
+func get(client *metadata.Client, path string) (string, error) {
+	data, err := client.Get(path)
+	if errors.Cause(err) == metadata.ErrNotFound {
+		return "", nil
+	}
+
+	return strings.TrimSpace(string(data)), err
+}
+
+// readJSONFile reads a json document from disk
+func readJSONFile(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return json.NewDecoder(f).Decode(v)
+}
+
+// appendIP parses and appends the ip if it is set
+func appendIP(ips []net.IP, s string) []net.IP {
+	if ip := net.ParseIP(s); ip != nil {
+		return append(ips, ip)
+	}
+
+	return ips
+}
+
+// appendName appends the dns name if it is set
+func appendName(names []string, name string) []string {
+	if name != "" {
+		return append(names, name)
+	}
+
+	return names
+}
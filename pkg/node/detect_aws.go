@@ -0,0 +1,40 @@
+package node
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/metadata"
+)
+
+// DefaultAWSMetadataEndpoint is the address of the EC2 instance metadata service
+const DefaultAWSMetadataEndpoint = metadata.DefaultAWSEndpoint
+
+// aws names the node after the private dns name of the instance, as the aws
+// cloud provider does
+func (d Detector) aws() (*Identity, error) {
+	client := metadata.NewAWS(d.MetadataEndpoint)
+
+	values := map[string]string{}
+	for _, key := range []string{"local-hostname", "local-ipv4", "public-hostname", "public-ipv4"} {
+		var err error
+		values[key], err = get(client, "/latest/meta-data/"+key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading aws metadata %s", key)
+		}
+	}
+
+	// custom dhcp options can return several names, the first is the private dns name
+	names := strings.Fields(values["local-hostname"])
+	if len(names) == 0 {
+		return nil, errors.New("no local-hostname in aws metadata")
+	}
+
+	identity := &Identity{Name: strings.ToLower(names[0])}
+	identity.DNSNames = appendName(identity.DNSNames, identity.Name)
+	identity.DNSNames = appendName(identity.DNSNames, values["public-hostname"])
+	identity.IPAddresses = appendIP(identity.IPAddresses, values["local-ipv4"])
+	identity.IPAddresses = appendIP(identity.IPAddresses, values["public-ipv4"])
+
+	return identity, nil
+}
@@ -0,0 +1,43 @@
+package node
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDetectAWS(t *testing.T) {
+	// Fake EC2 metadata service requiring IMDSv2
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" && r.Method == "PUT" {
+			w.Write([]byte("session-token"))
+			return
+		}
+
+		if r.Header.Get("X-aws-ec2-metadata-token") != "session-token" {
+			http.Error(w, "missing session token", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/latest/meta-data/local-hostname":
+			w.Write([]byte("ip-10-0-0-10.eu-west-1.compute.internal ip-10-0-0-10.example.com"))
+		case "/latest/meta-data/local-ipv4":
+			w.Write([]byte("10.0.0.10"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer metadata.Close()
+
+	identity, err := Detector{Source: SourceAWS, MetadataEndpoint: metadata.URL}.Detect()
+	if err != nil {
+		t.Fatalf("error detecting node identity: %s", err)
+	}
+
+	assertIdentity(t, identity,
+		"ip-10-0-0-10.eu-west-1.compute.internal",
+		[]string{"ip-10-0-0-10.eu-west-1.compute.internal"},
+		[]string{"10.0.0.10"},
+	)
+}
@@ -0,0 +1,64 @@
+package node
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/metadata"
+)
+
+// DefaultAzureMetadataEndpoint is the address of the Azure instance metadata service
+const DefaultAzureMetadataEndpoint = metadata.DefaultAzureEndpoint
+
+type azureInstance struct {
+	Compute struct {
+		Name           string `json:"name"`
+		VMScaleSetName string `json:"vmScaleSetName"`
+		OSProfile      struct {
+			ComputerName string `json:"computerName"`
+		} `json:"osProfile"`
+	} `json:"compute"`
+	Network struct {
+		Interface []struct {
+			IPv4 struct {
+				IPAddress []struct {
+					PrivateIPAddress string `json:"privateIpAddress"`
+					PublicIPAddress  string `json:"publicIpAddress"`
+				} `json:"ipAddress"`
+			} `json:"ipv4"`
+		} `json:"interface"`
+	} `json:"network"`
+}
+
+// azure names the node after the lowercase vm name, as the azure cloud
+// provider does. Scale set instances are named <vmss>_<n>, so they are named
+// after the computer name of the instance instead, which kubelet registers as.
+func (d Detector) azure() (*Identity, error) {
+	client := metadata.NewAzure(d.MetadataEndpoint)
+
+	var instance azureInstance
+	if err := client.GetJSON("/metadata/instance?api-version=2021-10-01", &instance); err != nil {
+		return nil, errors.Wrap(err, "reading azure instance metadata")
+	}
+
+	name := instance.Compute.Name
+	if instance.Compute.VMScaleSetName != "" {
+		name = instance.Compute.OSProfile.ComputerName
+	}
+
+	if name == "" {
+		return nil, errors.New("no vm name in azure metadata")
+	}
+
+	identity := &Identity{Name: strings.ToLower(name)}
+	identity.DNSNames = appendName(identity.DNSNames, identity.Name)
+
+	for _, iface := range instance.Network.Interface {
+		for _, addr := range iface.IPv4.IPAddress {
+			identity.IPAddresses = appendIP(identity.IPAddresses, addr.PrivateIPAddress)
+			identity.IPAddresses = appendIP(identity.IPAddresses, addr.PublicIPAddress)
+		}
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,51 @@
+package node
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDetectAzure(t *testing.T) {
+	cases := []struct {
+		name     string
+		compute  string
+		nodeName string
+	}{
+		{"vm", `{"name": "K-A-Node-S36B"}`, "k-a-node-s36b"},
+		{"scale set", `{"name": "k-a-nodes_3", "vmScaleSetName": "k-a-nodes", "osProfile": {"computerName": "K-A-Nodes000003"}}`, "k-a-nodes000003"},
+	}
+
+	for _, c := range cases {
+		// Fake Azure instance metadata service
+		metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Metadata") != "true" {
+				http.Error(w, "missing metadata header", http.StatusBadRequest)
+				return
+			}
+
+			if r.URL.Path != "/metadata/instance" {
+				http.NotFound(w, r)
+				return
+			}
+
+			w.Write([]byte(`{
+				"compute": ` + c.compute + `,
+				"network": {"interface": [{"ipv4": {"ipAddress": [{"privateIpAddress": "10.0.0.10", "publicIpAddress": ""}]}}]}
+			}`))
+		}))
+
+		identity, err := Detector{Source: SourceAzure, MetadataEndpoint: metadata.URL}.Detect()
+		metadata.Close()
+
+		if err != nil {
+			t.Fatalf("%s: error detecting node identity: %s", c.name, err)
+		}
+
+		assertIdentity(t, identity,
+			c.nodeName,
+			[]string{c.nodeName},
+			[]string{"10.0.0.10"},
+		)
+	}
+}
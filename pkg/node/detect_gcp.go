@@ -0,0 +1,46 @@
+package node
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/metadata"
+)
+
+// DefaultGCPMetadataEndpoint is the address of the GCE metadata server
+const DefaultGCPMetadataEndpoint = metadata.DefaultGCPEndpoint
+
+// gcp names the node after the instance name, which is the first label of the
+// instance hostname, as the gce cloud provider does
+func (d Detector) gcp() (*Identity, error) {
+	client := metadata.NewGCP(d.MetadataEndpoint)
+
+	values := map[string]string{}
+	for _, key := range []string{
+		"instance/hostname",
+		"instance/network-interfaces/0/ip",
+		"instance/network-interfaces/0/access-configs/0/external-ip",
+	} {
+		var err error
+		values[key], err = get(client, "/computeMetadata/v1/"+key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading gcp metadata %s", key)
+		}
+	}
+
+	hostname := strings.ToLower(values["instance/hostname"])
+	if hostname == "" {
+		return nil, errors.New("no hostname in gcp metadata")
+	}
+
+	identity := &Identity{Name: strings.Split(hostname, ".")[0]}
+	identity.DNSNames = appendName(identity.DNSNames, identity.Name)
+	if hostname != identity.Name {
+		identity.DNSNames = appendName(identity.DNSNames, hostname)
+	}
+
+	identity.IPAddresses = appendIP(identity.IPAddresses, values["instance/network-interfaces/0/ip"])
+	identity.IPAddresses = appendIP(identity.IPAddresses, values["instance/network-interfaces/0/access-configs/0/external-ip"])
+
+	return identity, nil
+}
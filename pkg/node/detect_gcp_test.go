@@ -0,0 +1,40 @@
+package node
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDetectGCP(t *testing.T) {
+	// Fake GCE metadata server
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing metadata flavor", http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/hostname":
+			w.Write([]byte("k-a-node-s36b.c.project.internal"))
+		case "/computeMetadata/v1/instance/network-interfaces/0/ip":
+			w.Write([]byte("10.0.0.10"))
+		case "/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip":
+			w.Write([]byte("203.0.113.10"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer metadata.Close()
+
+	identity, err := Detector{Source: SourceGCP, MetadataEndpoint: metadata.URL}.Detect()
+	if err != nil {
+		t.Fatalf("error detecting node identity: %s", err)
+	}
+
+	assertIdentity(t, identity,
+		"k-a-node-s36b",
+		[]string{"k-a-node-s36b", "k-a-node-s36b.c.project.internal"},
+		[]string{"10.0.0.10", "203.0.113.10"},
+	)
+}
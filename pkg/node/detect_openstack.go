@@ -0,0 +1,49 @@
+package node
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/util"
+)
+
+// DefaultConfigDrivePath is where the openstack config drive is expected to
+// be mounted
+const DefaultConfigDrivePath = "/mnt/config"
+
+// openstack names the node after the server name in the config drive
+// metadata, as the openstack cloud provider does
+func (d Detector) openstack() (*Identity, error) {
+	path := d.ConfigDrivePath
+	if path == "" {
+		path = DefaultConfigDrivePath
+	}
+
+	var metadata struct {
+		Name     string `json:"name"`
+		Hostname string `json:"hostname"`
+	}
+
+	if err := readJSONFile(filepath.Join(path, "openstack", "latest", "meta_data.json"), &metadata); err != nil {
+		return nil, errors.Wrap(err, "reading openstack config drive")
+	}
+
+	if metadata.Name == "" {
+		return nil, errors.New("no server name in openstack metadata")
+	}
+
+	// the config drive has no addresses, so they are read from the local interfaces
+	_, ips, err := util.DetectAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{Name: metadata.Name, IPAddresses: ips}
+	identity.DNSNames = appendName(identity.DNSNames, strings.ToLower(metadata.Name))
+	if hostname := strings.ToLower(metadata.Hostname); hostname != "" && hostname != identity.DNSNames[0] {
+		identity.DNSNames = appendName(identity.DNSNames, hostname)
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,42 @@
+package node
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectOpenStack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "openstack", "latest"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := []byte(`{"name": "k-a-node-s36b", "hostname": "k-a-node-s36b.novalocal"}`)
+	if err := ioutil.WriteFile(filepath.Join(dir, "openstack", "latest", "meta_data.json"), metadata, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := Detector{Source: SourceOpenStack, ConfigDrivePath: dir}.Detect()
+	if err != nil {
+		t.Fatalf("error detecting node identity: %s", err)
+	}
+
+	if identity.Name != "k-a-node-s36b" {
+		t.Errorf("expected node name k-a-node-s36b but got %q", identity.Name)
+	}
+
+	if len(identity.DNSNames) != 2 || identity.DNSNames[1] != "k-a-node-s36b.novalocal" {
+		t.Errorf("expected the config drive hostname in the dns names but got %v", identity.DNSNames)
+	}
+
+	if _, err := (Detector{Source: SourceOpenStack, ConfigDrivePath: filepath.Join(dir, "missing")}).Detect(); err == nil {
+		t.Errorf("expected a missing config drive to error")
+	}
+}
@@ -0,0 +1,49 @@
+package node
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestDetectHostname(t *testing.T) {
+	identity, err := Detector{Source: SourceHostname}.Detect()
+	if err != nil {
+		t.Fatalf("error detecting node identity: %s", err)
+	}
+
+	if identity.Name == "" || identity.Name != strings.ToLower(identity.Name) {
+		t.Errorf("expected a lowercase node name but got %q", identity.Name)
+	}
+
+	if len(identity.DNSNames) != 1 || identity.DNSNames[0] != identity.Name {
+		t.Errorf("expected the node name as the only dns name but got %v", identity.DNSNames)
+	}
+}
+
+func TestDetectUnknownSource(t *testing.T) {
+	if _, err := (Detector{Source: "vsphere"}).Detect(); err == nil {
+		t.Errorf("expected unknown source to error")
+	}
+}
+
+// assertIdentity checks the detected identity matches the expected name and addresses
+func assertIdentity(t *testing.T, identity *Identity, name string, dnsNames []string, ips []string) {
+	if identity.Name != name {
+		t.Errorf("expected node name %q but got %q", name, identity.Name)
+	}
+
+	if strings.Join(identity.DNSNames, ",") != strings.Join(dnsNames, ",") {
+		t.Errorf("expected dns names %v but got %v", dnsNames, identity.DNSNames)
+	}
+
+	if len(identity.IPAddresses) != len(ips) {
+		t.Fatalf("expected ip addresses %v but got %v", ips, identity.IPAddresses)
+	}
+
+	for i, ip := range ips {
+		if !identity.IPAddresses[i].Equal(net.ParseIP(ip)) {
+			t.Errorf("expected ip addresses %v but got %v", ips, identity.IPAddresses)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// DetectAddresses returns the hostname and the addresses of the local
+// interfaces, skipping loopback and link local addresses
+func DetectAddresses() (dnsNames []string, ips []net.IP, err error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reading hostname")
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reading interface addresses")
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		ip := ipNet.IP
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+			continue
+		}
+
+		ips = append(ips, ip)
+	}
+
+	return []string{hostname}, ips, nil
+}
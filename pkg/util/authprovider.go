@@ -1,28 +1,90 @@
 package util
 
 import (
+	"strings"
+
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/vault/token"
+	"k8s.io/client-go/kubernetes"
 )
 
 // FlagAuthProvider creates flags for vault auth providers
 func FlagAuthProvider(ptr *token.AuthProvider, fs *pflag.FlagSet) {
-	provider := authProvider{
+	provider := &authProvider{
 		ptr: ptr,
 	}
 
-	fs.Var(provider, "vault-auth", "method to use for vault auth (kubernetes|approle)")
+	fs.Var(provider, "vault-auth", "methods to use for vault auth, tried in order (kubernetes|approle|aws|gcp|azure|token|userpass|ldap)")
 
 	// Vault Kubernetes auth flags
 	fs.StringVar(&provider.kubernetes.Mount, "kubernetes-auth-mount", "kubernetes", "name of the kubernetes auth mount in vault")
 	fs.StringVar(&provider.kubernetes.Role, "kubernetes-auth-role", "", "role to use when authenticating with vault using the service token")
-	fs.StringVar(&provider.kubernetes.TokenFile, "kubernetes-auth-token-file", "/var/run/secrets/kubernetes.io/serviceaccount", "file to load service token from")
+	fs.StringVar(&provider.kubernetes.TokenFile, "kubernetes-auth-token-file", token.DefaultServiceAccountTokenFile, "file to load service token from")
+	fs.StringSliceVar(&provider.kubernetes.Audiences, "kubernetes-auth-audience", nil, "request a service token for these audiences using the TokenRequest API instead of reading the token file")
+	fs.StringVar(&provider.kubernetes.ServiceAccount, "kubernetes-auth-service-account", "", "service account to request tokens for when using audiences, defaults to the service account of the token file")
+	fs.StringVar(&provider.kubernetes.Namespace, "kubernetes-auth-namespace", "", "namespace of the service account, defaults to the namespace next to the token file")
+	fs.DurationVar(&provider.kubernetes.TokenExpiration, "kubernetes-auth-token-expiration", 0, "requested lifetime of service tokens when using audiences")
 
 	// Vault AppRole auth flags
 	fs.StringVar(&provider.appRole.Mount, "approle-auth-mount", "", "name of the approle auth mount in vault")
 	fs.StringVar(&provider.appRole.RoleID, "approle-auth-roleid", "", "vault role id to use when authenticating with an approle")
 	fs.StringVar(&provider.appRole.SecretID, "approle-auth-secretid", "", "vault secret id to use when authenticating with an approle")
+	fs.StringVar(&provider.appRole.RoleIDFile, "approle-auth-roleid-file", "", "file to load the vault role id from, read on every login")
+	fs.StringVar(&provider.appRole.SecretIDFile, "approle-auth-secretid-file", "", "file to load the vault secret id from, read on every login")
+	fs.StringVar(&provider.appRole.SecretIDWrappingToken, "approle-auth-secretid-wrapping-token", "", "response wrapping token containing the vault secret id")
+	fs.StringVar(&provider.appRole.SecretIDWrappingTokenFile, "approle-auth-secretid-wrapping-token-file", "", "file to load a response wrapping token containing the vault secret id from, read on every login")
+
+	// Vault AWS IAM auth flags
+	fs.StringVar(&provider.awsIAM.Mount, "aws-auth-mount", "aws", "name of the aws auth mount in vault")
+	fs.StringVar(&provider.awsIAM.Role, "aws-auth-role", "", "role to use when authenticating with vault using aws iam")
+	fs.StringVar(&provider.awsIAM.HeaderValue, "aws-auth-header-value", "", "value of the X-Vault-AWS-IAM-Server-ID header to sign")
+	fs.StringVar(&provider.awsIAM.STSEndpoint, "aws-auth-sts-endpoint", token.DefaultAWSSTSEndpoint, "sts endpoint to sign the GetCallerIdentity request for")
+	fs.StringVar(&provider.awsIAM.STSRegion, "aws-auth-sts-region", "us-east-1", "region to sign the GetCallerIdentity request for")
+
+	// Vault GCP auth flags
+	fs.StringVar(&provider.gcp.Mount, "gcp-auth-mount", "gcp", "name of the gcp auth mount in vault")
+	fs.StringVar(&provider.gcp.Role, "gcp-auth-role", "", "role to use when authenticating with vault using the gce instance identity")
+	fs.StringVar(&provider.gcp.ServiceAccount, "gcp-auth-service-account", "default", "instance service account to request the identity token for")
+	fs.StringVar(&provider.gcp.Audience, "gcp-auth-audience", "", "audience of the identity token, defaults to http://vault/<role>")
+
+	// Vault Azure auth flags
+	fs.StringVar(&provider.azure.Mount, "azure-auth-mount", "azure", "name of the azure auth mount in vault")
+	fs.StringVar(&provider.azure.Role, "azure-auth-role", "", "role to use when authenticating with vault using the azure managed identity")
+	fs.StringVar(&provider.azure.Resource, "azure-auth-resource", token.DefaultAzureResource, "resource to request the managed identity token for")
+	fs.StringVar(&provider.azure.SubscriptionID, "azure-auth-subscription-id", "", "subscription id of the vm, defaults to the value from instance metadata")
+	fs.StringVar(&provider.azure.ResourceGroupName, "azure-auth-resource-group", "", "resource group of the vm, defaults to the value from instance metadata")
+	fs.StringVar(&provider.azure.VMName, "azure-auth-vm-name", "", "name of the vm, defaults to the value from instance metadata")
+	fs.StringVar(&provider.azure.VMSSName, "azure-auth-vmss-name", "", "name of the vm scale set, defaults to the value from instance metadata")
+
+	// Vault token auth flags
+	fs.StringVar(&provider.token.File, "token-auth-file", "", "file to load the vault token from, such as a vault agent sink, defaults to VAULT_TOKEN")
+
+	// Vault userpass and LDAP auth flags
+	flagPasswordAuth(&provider.userpass, "userpass", fs)
+	flagPasswordAuth((*token.AuthProviderUserpass)(&provider.ldap), "ldap", fs)
+}
+
+func flagPasswordAuth(provider *token.AuthProviderUserpass, method string, fs *pflag.FlagSet) {
+	fs.StringVar(&provider.Mount, method+"-auth-mount", method, "name of the "+method+" auth mount in vault")
+	fs.StringVar(&provider.Username, method+"-auth-username", "", "username to use when authenticating with "+method)
+	fs.StringVar(&provider.Password, method+"-auth-password", "", "password to use when authenticating with "+method+", prompted for if not set")
+	fs.StringVar(&provider.PasswordFile, method+"-auth-password-file", "", "file to load the "+method+" password from")
+	fs.StringVar(&provider.Passcode, method+"-auth-passcode", "", "mfa passcode to use when authenticating with "+method+", prompted for if required")
+	fs.StringVar(&provider.MFAMethod, method+"-auth-mfa-method", "", "mfa method to use when authenticating with "+method)
+}
+
+// SetAuthProviderKubernetesClient sets the kubernetes client used by auth
+// providers that need to talk to the kubernetes api
+func SetAuthProviderKubernetesClient(provider token.AuthProvider, client kubernetes.Interface) {
+	switch p := provider.(type) {
+	case *token.AuthProviderKubernetes:
+		p.Client = client
+	case *token.AuthProviderChain:
+		for _, provider := range p.Providers {
+			SetAuthProviderKubernetesClient(provider, client)
+		}
+	}
 }
 
 type authProvider struct {
@@ -30,9 +92,15 @@ type authProvider struct {
 
 	kubernetes token.AuthProviderKubernetes
 	appRole    token.AuthProviderAppRole
+	awsIAM     token.AuthProviderAWSIAM
+	gcp        token.AuthProviderGCP
+	azure      token.AuthProviderAzure
+	token      token.AuthProviderToken
+	userpass   token.AuthProviderUserpass
+	ldap       token.AuthProviderLDAP
 }
 
-func (a authProvider) String() string {
+func (a *authProvider) String() string {
 	if *a.ptr != nil {
 		return (*a.ptr).String()
 	}
@@ -40,21 +108,57 @@ func (a authProvider) String() string {
 	return ""
 }
 
-func (a authProvider) Set(provider string) error {
-	switch provider {
-	case "kubernetes":
-		*a.ptr = a.kubernetes
-	case "approle":
-		*a.ptr = a.appRole
-	case "":
+func (a *authProvider) Set(value string) error {
+	var providers []token.AuthProvider
+
+	for _, name := range strings.Split(value, ",") {
+		provider, err := a.provider(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+
+		if provider != nil {
+			providers = append(providers, provider)
+		}
+	}
+
+	switch len(providers) {
+	case 0:
 		*a.ptr = nil
+	case 1:
+		*a.ptr = providers[0]
 	default:
-		return errors.Errorf("unknown auth provider: %s", provider)
+		*a.ptr = &token.AuthProviderChain{Providers: providers}
 	}
 
 	return nil
 }
 
-func (a authProvider) Type() string {
+func (a *authProvider) provider(name string) (token.AuthProvider, error) {
+	switch name {
+	case "kubernetes":
+		return &a.kubernetes, nil
+	case "approle":
+		return &a.appRole, nil
+	case "aws":
+		return &a.awsIAM, nil
+	case "gcp":
+		return &a.gcp, nil
+	case "azure":
+		return &a.azure, nil
+	case "token":
+		return &a.token, nil
+	case "userpass":
+		return &a.userpass, nil
+	case "ldap":
+		return &a.ldap, nil
+	case "":
+		return nil, nil
+	default:
+		return nil, errors.Errorf("unknown auth provider: %s", name)
+	}
+}
+
+func (a *authProvider) Type() string {
 	return "string"
 }
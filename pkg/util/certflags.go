@@ -0,0 +1,49 @@
+package util
+
+import (
+	"github.com/spf13/pflag"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/controller/certificate/bootstrap"
+)
+
+// KubeconfigFlags holds the flags used to write a kubeconfig
+type KubeconfigFlags struct {
+	MasterAddr        string
+	Insecure          bool
+	Path              string
+	Names             bootstrap.KubeconfigNames
+	Merge             bool
+	SetCurrentContext bool
+}
+
+// FlagVault creates the vault address and token revocation flags
+func FlagVault(address *string, revokeToken *bool, fs *pflag.FlagSet) {
+	fs.StringVar(address, "vault-address", "", "vault server address")
+	fs.BoolVar(revokeToken, "vault-revoke-token", false, "revoke the vault token once the certificate is issued")
+}
+
+// FlagPKI creates the vault pki flags, the default ttl differs between the
+// certificates each command creates
+func FlagPKI(mount, role, ttl *string, defaultTTL string, fs *pflag.FlagSet) {
+	fs.StringVar(mount, "vault-pki-mount", "pki", "specify the pki mount to use to generate certificates")
+	fs.StringVar(role, "vault-pki-role", "", "specify role to use")
+	fs.StringVar(ttl, "vault-pki-ttl", defaultTTL, "ttl of the certificate")
+}
+
+// FlagKeyConfig creates the private key flags
+func FlagKeyConfig(keyConfig *bootstrap.KeyConfig, fs *pflag.FlagSet) {
+	fs.StringVar(&keyConfig.Algorithm, "key-algorithm", "", "algorithm of the private key (ecdsa|rsa), defaults to ecdsa, not used with --mode=issue where the role sets the key type")
+	fs.IntVar(&keyConfig.Size, "key-size", 0, "size of the private key, defaults to 256 for ecdsa and 2048 for rsa")
+}
+
+// FlagKubeconfig creates the kubeconfig output flags
+func FlagKubeconfig(kubeconfig *KubeconfigFlags, fs *pflag.FlagSet) {
+	fs.StringVar(&kubeconfig.MasterAddr, "output-kubeconfig-master-url", "", "url of the apiserver")
+	fs.BoolVar(&kubeconfig.Insecure, "output-kubeconfig-insecure", false, "allow insecure certificates for the apiserver")
+	fs.StringVar(&kubeconfig.Path, "output-kubeconfig-path", "", "path to write kubeconfig to")
+	fs.StringVar(&kubeconfig.Names.Cluster, "output-kubeconfig-cluster-name", bootstrap.DefaultKubeconfigNames.Cluster, "name of the cluster in the kubeconfig")
+	fs.StringVar(&kubeconfig.Names.User, "output-kubeconfig-user-name", bootstrap.DefaultKubeconfigNames.User, "name of the user in the kubeconfig")
+	fs.StringVar(&kubeconfig.Names.Context, "output-kubeconfig-context-name", bootstrap.DefaultKubeconfigNames.Context, "name of the context in the kubeconfig")
+	fs.StringVar(&kubeconfig.Names.Namespace, "output-kubeconfig-namespace", bootstrap.DefaultKubeconfigNames.Namespace, "namespace of the context in the kubeconfig")
+	fs.BoolVar(&kubeconfig.Merge, "output-kubeconfig-merge", false, "add or replace the cluster, user and context in an existing kubeconfig instead of overwriting it")
+	fs.BoolVar(&kubeconfig.SetCurrentContext, "output-kubeconfig-set-current-context", false, "switch the current context of a merged kubeconfig to the new context")
+}
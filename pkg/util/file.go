@@ -0,0 +1,89 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileOwner is the numeric owner of a file, -1 leaves the id unchanged
+type FileOwner struct {
+	UID int
+	GID int
+}
+
+// NoFileOwner leaves the ownership of written files unchanged
+var NoFileOwner = FileOwner{UID: -1, GID: -1}
+
+// ParseFileOwner parses an owner in the format uid[:gid], an empty string
+// returns NoFileOwner
+func ParseFileOwner(s string) (FileOwner, error) {
+	owner := NoFileOwner
+
+	if s == "" {
+		return owner, nil
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil || uid < 0 {
+		return owner, errors.Errorf("invalid uid %q", parts[0])
+	}
+
+	owner.UID = uid
+
+	if len(parts) == 2 {
+		gid, err := strconv.Atoi(parts[1])
+		if err != nil || gid < 0 {
+			return owner, errors.Errorf("invalid gid %q", parts[1])
+		}
+
+		owner.GID = gid
+	}
+
+	return owner, nil
+}
+
+// WriteFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never see a partially written file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode, owner FileOwner) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "creating %s", path)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "setting permissions of %s", path)
+	}
+
+	if owner != NoFileOwner {
+		if err := tmp.Chown(owner.UID, owner.GID); err != nil {
+			tmp.Close()
+			return errors.Wrapf(err, "setting owner of %s", path)
+		}
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "writing %s", path)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "writing %s", path)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "writing %s", path)
+	}
+
+	return errors.Wrapf(os.Rename(tmp.Name(), path), "writing %s", path)
+}
@@ -0,0 +1,84 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFileOwner(t *testing.T) {
+	cases := []struct {
+		owner    string
+		expected FileOwner
+		valid    bool
+	}{
+		{"", NoFileOwner, true},
+		{"1000", FileOwner{UID: 1000, GID: -1}, true},
+		{"1000:2000", FileOwner{UID: 1000, GID: 2000}, true},
+		{"kubelet", NoFileOwner, false},
+		{"1000:kubelet", NoFileOwner, false},
+		{"-5", NoFileOwner, false},
+	}
+
+	for _, c := range cases {
+		owner, err := ParseFileOwner(c.owner)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("%q: expected error", c.owner)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", c.owner, err)
+		} else if owner != c.expected {
+			t.Errorf("%q: expected %+v but got %+v", c.owner, c.expected, owner)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "key.pem")
+
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(path, []byte("new"), 0600, NoFileOwner); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "new" {
+		t.Errorf("expected file to contain 'new' but got: %s", data)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected permissions 0600 but got: %v", info.Mode().Perm())
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Errorf("expected temporary files to be removed, found %d files", len(files))
+	}
+}
@@ -0,0 +1,127 @@
+package health
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// ErrNotChecked is reported until the first health check completes
+var ErrNotChecked = errors.New("vault health not checked yet")
+
+// ErrSealed is reported while vault is sealed
+var ErrSealed = errors.New("vault is sealed")
+
+// ErrUninitialized is reported while vault is not initialized
+var ErrUninitialized = errors.New("vault is not initialized")
+
+// Monitor polls the vault sys/health endpoint and tracks whether vault is
+// able to serve requests. Standby nodes forward requests to the active node
+// so are considered healthy.
+type Monitor struct {
+	client   *api.Client
+	interval time.Duration
+
+	lock    sync.Mutex
+	err     error
+	healthy chan struct{}
+	stopped chan struct{}
+}
+
+// NewMonitor creates a monitor that checks the health of vault every interval
+func NewMonitor(client *api.Client, interval time.Duration) *Monitor {
+	return &Monitor{
+		client:   client,
+		interval: interval,
+		err:      ErrNotChecked,
+		healthy:  make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Run checks the health of vault until the stop channel is closed, once
+// stopped any callers of Wait are released
+func (m *Monitor) Run(stopCh <-chan struct{}) error {
+	defer close(m.stopped)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.set(m.check())
+
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return nil
+		}
+	}
+}
+
+// Err returns the reason vault is unhealthy, or nil if it is healthy
+func (m *Monitor) Err() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.err
+}
+
+// Wait blocks until vault is healthy, it returns false if the monitor stopped
+// before vault became healthy
+func (m *Monitor) Wait() bool {
+	m.lock.Lock()
+	healthy := m.healthy
+	m.lock.Unlock()
+
+	select {
+	case <-healthy:
+		return true
+	case <-m.stopped:
+		return false
+	}
+}
+
+// check calls the sys/health endpoint, the api client requests a 2xx
+// response for every state so only unreachable servers return an error
+func (m *Monitor) check() error {
+	health, err := m.client.Sys().Health()
+	if err != nil {
+		return errors.Wrap(err, "checking vault health")
+	}
+
+	if !health.Initialized {
+		return ErrUninitialized
+	}
+
+	if health.Sealed {
+		return ErrSealed
+	}
+
+	return nil
+}
+
+// set records the result of a check, the healthy channel is closed while
+// vault is healthy and replaced when it becomes unhealthy
+func (m *Monitor) set(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	previous := m.err
+	m.err = err
+
+	switch {
+	case err == nil && previous != nil:
+		glog.Info("vault is healthy, resuming signing")
+		close(m.healthy)
+	case err != nil && previous == nil:
+		glog.Warningf("vault is unhealthy, pausing signing: %s", err)
+		m.healthy = make(chan struct{})
+	case err != nil && previous == ErrNotChecked:
+		glog.Warningf("vault is unhealthy, pausing signing: %s", err)
+	case err != nil:
+		glog.V(1).Infof("vault is still unhealthy: %s", err)
+	}
+}
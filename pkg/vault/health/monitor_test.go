@@ -0,0 +1,127 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// fakeHealthServer serves sys/health with a configurable seal status
+type fakeHealthServer struct {
+	lock   sync.Mutex
+	sealed bool
+}
+
+func (s *fakeHealthServer) setSealed(sealed bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.sealed = sealed
+}
+
+func (s *fakeHealthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/sys/health" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"initialized": true,
+		"sealed":      s.sealed,
+		"standby":     false,
+	})
+}
+
+func TestMonitor(t *testing.T) {
+	fake := &fakeHealthServer{sealed: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	monitor := NewMonitor(client, 10*time.Millisecond)
+
+	if err := monitor.Err(); err != ErrNotChecked {
+		t.Errorf("expected not checked before running, got %v", err)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- monitor.Run(stop) }()
+
+	waited := make(chan bool)
+	go func() { waited <- monitor.Wait() }()
+
+	// sealed vault blocks signing
+
+	deadline := time.Now().Add(time.Second)
+	for monitor.Err() != ErrSealed && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := monitor.Err(); err != ErrSealed {
+		t.Fatalf("expected sealed, got %v", err)
+	}
+
+	select {
+	case <-waited:
+		t.Fatal("expected wait to block while vault is sealed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// unsealing resumes signing
+
+	fake.setSealed(false)
+
+	select {
+	case ok := <-waited:
+		if !ok {
+			t.Errorf("expected wait to report vault healthy")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected wait to return once vault is unsealed")
+	}
+
+	if err := monitor.Err(); err != nil {
+		t.Errorf("expected healthy, got %v", err)
+	}
+
+	// sealing again blocks signing
+
+	fake.setSealed(true)
+
+	deadline = time.Now().Add(time.Second)
+	for monitor.Err() == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	go func() { waited <- monitor.Wait() }()
+
+	// stopping the monitor releases waiters
+
+	close(stop)
+
+	select {
+	case ok := <-waited:
+		if ok {
+			t.Errorf("expected wait to report stopped")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected wait to return once the monitor stopped")
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error from run: %s", err)
+	}
+}
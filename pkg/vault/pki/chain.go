@@ -0,0 +1,94 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+// ErrInvalidChain is returned when the ca chain does not contain the issuer
+// of a certificate in the bundle
+var ErrInvalidChain = errors.New("ca chain does not form a chain to the certificate")
+
+// Chain returns the certificate followed by the ca certificates in the order
+// they sign each other. The chain ends at a self signed root, or at the last
+// ca whose issuer vault did not return. The root is left out when excludeRoot
+// is set, certificates in the ca chain that are not part of the chain are
+// ignored.
+func (c *Certificate) Chain(excludeRoot bool) ([]*x509.Certificate, error) {
+	chain := []*x509.Certificate{c.Certificate}
+	root := false
+
+	for current := c.Certificate; ; {
+		issuer := findIssuer(current, c.CAChain)
+		if issuer == nil {
+			break
+		}
+
+		for _, crt := range chain {
+			if crt.Equal(issuer) {
+				return nil, errors.Wrapf(ErrInvalidChain, "loop at %q", issuer.Subject.CommonName)
+			}
+		}
+
+		chain = append(chain, issuer)
+		current = issuer
+
+		if isSelfSigned(issuer) {
+			root = true
+			break
+		}
+	}
+
+	// the certificate must at least be signed by the issuing ca
+	if len(chain) == 1 {
+		return nil, errors.Wrapf(ErrInvalidChain, "no issuer found for %q", c.Certificate.Subject.CommonName)
+	}
+
+	if excludeRoot && root {
+		chain = chain[:len(chain)-1]
+	}
+
+	return chain, nil
+}
+
+// Bundle returns the PEM encoded certificate chain, see Chain
+func (c *Certificate) Bundle(excludeRoot bool) ([]byte, error) {
+	chain, err := c.Chain(excludeRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var bundle []byte
+	for _, crt := range chain {
+		bundle = append(bundle, certutil.EncodeCertPEM(crt)...)
+	}
+
+	return bundle, nil
+}
+
+// findIssuer returns the certificate in cas that signed crt
+func findIssuer(crt *x509.Certificate, cas []*x509.Certificate) *x509.Certificate {
+	for _, ca := range cas {
+		if !bytes.Equal(crt.RawIssuer, ca.RawSubject) {
+			continue
+		}
+
+		if crt.CheckSignatureFrom(ca) == nil {
+			return ca
+		}
+	}
+
+	return nil
+}
+
+// isSelfSigned returns true if the certificate is a self signed ca
+func isSelfSigned(crt *x509.Certificate) bool {
+	if !bytes.Equal(crt.RawIssuer, crt.RawSubject) {
+		return false
+	}
+
+	return crt.CheckSignatureFrom(crt) == nil
+}
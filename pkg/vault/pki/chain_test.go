@@ -0,0 +1,67 @@
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/pkg/errors"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+func TestChain(t *testing.T) {
+	root := newTestCert(t, pkix.Name{CommonName: "root"}, true, nil)
+	intermediate := newTestCert(t, pkix.Name{CommonName: "intermediate"}, true, root)
+	leaf := newTestCert(t, pkix.Name{CommonName: "leaf"}, false, intermediate)
+	other := newTestCert(t, pkix.Name{CommonName: "other"}, true, nil)
+
+	cases := []struct {
+		name        string
+		caChain     []*x509.Certificate
+		excludeRoot bool
+		expected    []*x509.Certificate
+		err         bool
+	}{
+		{"full chain", []*x509.Certificate{intermediate.cert, root.cert}, false, []*x509.Certificate{leaf.cert, intermediate.cert, root.cert}, false},
+		{"exclude root", []*x509.Certificate{intermediate.cert, root.cert}, true, []*x509.Certificate{leaf.cert, intermediate.cert}, false},
+		{"reversed chain", []*x509.Certificate{root.cert, intermediate.cert}, false, []*x509.Certificate{leaf.cert, intermediate.cert, root.cert}, false},
+		{"unrelated ca", []*x509.Certificate{other.cert, intermediate.cert}, false, []*x509.Certificate{leaf.cert, intermediate.cert}, false},
+		{"no root", []*x509.Certificate{intermediate.cert}, true, []*x509.Certificate{leaf.cert, intermediate.cert}, false},
+		{"no issuer", []*x509.Certificate{root.cert}, false, nil, true},
+		{"wrong issuer", []*x509.Certificate{other.cert}, false, nil, true},
+	}
+
+	for _, c := range cases {
+		response := &Certificate{Certificate: leaf.cert, CAChain: c.caChain}
+
+		bundle, err := response.Bundle(c.excludeRoot)
+		if c.err {
+			if errors.Cause(err) != ErrInvalidChain {
+				t.Errorf("%s: expected invalid chain error, got %v", c.name, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+			continue
+		}
+
+		certs, err := certutil.ParseCertsPEM(bundle)
+		if err != nil {
+			t.Errorf("%s: error parsing bundle: %s", c.name, err)
+			continue
+		}
+
+		if len(certs) != len(c.expected) {
+			t.Errorf("%s: expected %d certificates, got %d", c.name, len(c.expected), len(certs))
+			continue
+		}
+
+		for i := range certs {
+			if !certs[i].Equal(c.expected[i]) {
+				t.Errorf("%s: expected %q at %d, got %q", c.name, c.expected[i].Subject.CommonName, i, certs[i].Subject.CommonName)
+			}
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+// ErrNoResponse is returned when vault returns no data for a pki request
+var ErrNoResponse = errors.New("no data in vault pki response")
+
+// ErrPublicKeyMismatch is returned when the issued certificate is not for the
+// submitted key or CSR
+var ErrPublicKeyMismatch = errors.New("certificate public key does not match the request")
+
+// ErrSubjectMismatch is returned when the subject of the issued certificate
+// differs from the requested subject
+var ErrSubjectMismatch = errors.New("certificate subject does not match the request")
+
+// ErrServingMismatch is returned when the issued certificate cannot serve the
+// requested dns names and ip addresses
+var ErrServingMismatch = errors.New("certificate cannot serve the requested names")
+
+// FieldError is returned when a field of a pki response is missing or has an
+// unexpected type or content
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("vault pki response field %s: %s", e.Field, e.Reason)
+}
+
+// Certificate is a parsed pki issue or sign response
+type Certificate struct {
+	// Certificate is the issued certificate
+	Certificate *x509.Certificate
+
+	// CAChain is the chain of the issuing ca, starting with the issuing ca
+	CAChain []*x509.Certificate
+
+	// CertificatePEM is the PEM encoded issued certificate
+	CertificatePEM []byte
+
+	// CAPEM is the PEM encoded ca chain
+	CAPEM []byte
+
+	// PrivateKeyPEM is the PEM encoded private key, only returned by issue
+	PrivateKeyPEM []byte
+}
+
+// ParseResponse parses the response of the pki issue, sign and sign-verbatim
+// endpoints. Each field may be a string or a list of strings, ca_chain is
+// used for the ca when returned and issuing_ca otherwise.
+func ParseResponse(secret *api.Secret) (*Certificate, error) {
+	if secret == nil || len(secret.Data) == 0 {
+		return nil, ErrNoResponse
+	}
+
+	certs, err := certificatesField(secret.Data, "certificate")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(certs) == 0 {
+		return nil, &FieldError{Field: "certificate", Reason: "missing"}
+	}
+
+	// ca_chain is only returned when signing from an intermediate
+	chain, err := certificatesField(secret.Data, "ca_chain")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chain) == 0 {
+		chain, err = certificatesField(secret.Data, "issuing_ca")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(chain) == 0 {
+		return nil, &FieldError{Field: "issuing_ca", Reason: "missing"}
+	}
+
+	key, err := stringField(secret.Data, "private_key")
+	if err != nil {
+		return nil, err
+	}
+
+	// a bundled certificate field is followed by its chain
+	c := &Certificate{
+		Certificate:    certs[0],
+		CAChain:        appendUnique(certs[1:], chain...),
+		CertificatePEM: certutil.EncodeCertPEM(certs[0]),
+	}
+
+	for _, crt := range c.CAChain {
+		c.CAPEM = append(c.CAPEM, certutil.EncodeCertPEM(crt)...)
+	}
+
+	if key != "" {
+		c.PrivateKeyPEM = []byte(strings.TrimSpace(key) + "\n")
+	}
+
+	return c, nil
+}
+
+// VerifyPublicKey checks the certificate was issued for the public key
+func (c *Certificate) VerifyPublicKey(pub crypto.PublicKey) error {
+	expected, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return errors.Wrap(err, "marshal public key")
+	}
+
+	actual, err := x509.MarshalPKIXPublicKey(c.Certificate.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "marshal certificate public key")
+	}
+
+	if !bytes.Equal(expected, actual) {
+		return ErrPublicKeyMismatch
+	}
+
+	return nil
+}
+
+// VerifyPrivateKey checks the certificate was issued for the PEM encoded
+// private key
+func (c *Certificate) VerifyPrivateKey(keyPEM []byte) error {
+	key, err := certutil.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return errors.Wrap(err, "parse private key")
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return errors.Errorf("unsupported private key type %T", key)
+	}
+
+	return c.VerifyPublicKey(signer.Public())
+}
+
+// VerifyRequest checks the certificate was issued for the public key and
+// subject of the PEM encoded CSR
+func (c *Certificate) VerifyRequest(csrPEM []byte) error {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return errors.New("no certificate request found")
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return errors.Wrap(err, "parse certificate request")
+	}
+
+	if err := c.VerifyPublicKey(csr.PublicKey); err != nil {
+		return err
+	}
+
+	return c.VerifySubject(csr.Subject.CommonName, csr.Subject.Organization)
+}
+
+// VerifySubject checks the common name and organizations of the certificate,
+// the order of the organizations is ignored
+func (c *Certificate) VerifySubject(commonName string, organization []string) error {
+	subject := c.Certificate.Subject
+
+	if subject.CommonName != commonName {
+		return errors.Wrapf(ErrSubjectMismatch, "expected common name %q, got %q", commonName, subject.CommonName)
+	}
+
+	if !equalUnordered(subject.Organization, organization) {
+		return errors.Wrapf(ErrSubjectMismatch, "expected organization %v, got %v", organization, subject.Organization)
+	}
+
+	return nil
+}
+
+// VerifyServing checks the certificate can be used for server auth and holds
+// the requested dns names and ip addresses, vault may add other names
+func (c *Certificate) VerifyServing(dnsNames []string, ipAddresses []net.IP) error {
+	crt := c.Certificate
+
+	serverAuth := false
+	for _, usage := range crt.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth {
+			serverAuth = true
+		}
+	}
+
+	if !serverAuth {
+		return errors.Wrap(ErrServingMismatch, "server auth extended key usage is missing")
+	}
+
+next:
+	for _, name := range dnsNames {
+		for _, n := range crt.DNSNames {
+			if strings.EqualFold(n, name) {
+				continue next
+			}
+		}
+
+		return errors.Wrapf(ErrServingMismatch, "dns name %q is missing from %v", name, crt.DNSNames)
+	}
+
+nextIP:
+	for _, ip := range ipAddresses {
+		for _, i := range crt.IPAddresses {
+			if i.Equal(ip) {
+				continue nextIP
+			}
+		}
+
+		return errors.Wrapf(ErrServingMismatch, "ip address %s is missing from %v", ip, crt.IPAddresses)
+	}
+
+	return nil
+}
+
+// stringField returns a field that is a string or a list of strings, lists
+// are joined with newlines
+func stringField(data map[string]interface{}, field string) (string, error) {
+	switch value := data[field].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case []string:
+		return strings.Join(value, "\n"), nil
+	case []interface{}:
+		values := make([]string, 0, len(value))
+
+		for _, v := range value {
+			s, ok := v.(string)
+			if !ok {
+				return "", &FieldError{Field: field, Reason: fmt.Sprintf("unexpected list element type %T", v)}
+			}
+
+			values = append(values, s)
+		}
+
+		return strings.Join(values, "\n"), nil
+	default:
+		return "", &FieldError{Field: field, Reason: fmt.Sprintf("unexpected type %T", value)}
+	}
+}
+
+// certificatesField parses the PEM encoded certificates in a field, a missing
+// or empty field returns no certificates
+func certificatesField(data map[string]interface{}, field string) ([]*x509.Certificate, error) {
+	value, err := stringField(data, field)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	certs, err := certutil.ParseCertsPEM([]byte(value))
+	if err != nil {
+		return nil, &FieldError{Field: field, Reason: err.Error()}
+	}
+
+	return certs, nil
+}
+
+// appendUnique appends the certificates that are not already in certs
+func appendUnique(certs []*x509.Certificate, add ...*x509.Certificate) []*x509.Certificate {
+	result := append([]*x509.Certificate{}, certs...)
+
+next:
+	for _, a := range add {
+		for _, c := range result {
+			if c.Equal(a) {
+				continue next
+			}
+		}
+
+		result = append(result, a)
+	}
+
+	return result
+}
+
+func equalUnordered(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+
+	sort.Strings(a)
+	sort.Strings(b)
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
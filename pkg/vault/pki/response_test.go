@@ -0,0 +1,285 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+type testCert struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+	pem  string
+}
+
+// newTestCert creates a certificate signed by the parent, or self signed if
+// the parent is nil
+func newTestCert(t *testing.T, subject pkix.Name, isCA bool, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+
+	parentCert, parentKey := template, crypto.Signer(key)
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{key: key, cert: cert, pem: string(certutil.EncodeCertPEM(cert))}
+}
+
+func keyPEM(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func TestParseResponse(t *testing.T) {
+	root := newTestCert(t, pkix.Name{CommonName: "root"}, true, nil)
+	intermediate := newTestCert(t, pkix.Name{CommonName: "intermediate"}, true, root)
+	leaf := newTestCert(t, pkix.Name{CommonName: "system:node:k-a-node-s36b", Organization: []string{"system:nodes"}}, false, intermediate)
+
+	cases := []struct {
+		name  string
+		data  map[string]interface{}
+		chain []*x509.Certificate
+		err   bool
+	}{
+		{
+			name:  "issuing ca",
+			data:  map[string]interface{}{"certificate": leaf.pem, "issuing_ca": intermediate.pem},
+			chain: []*x509.Certificate{intermediate.cert},
+		},
+		{
+			name:  "ca chain string",
+			data:  map[string]interface{}{"certificate": leaf.pem, "issuing_ca": intermediate.pem, "ca_chain": intermediate.pem + root.pem},
+			chain: []*x509.Certificate{intermediate.cert, root.cert},
+		},
+		{
+			name:  "ca chain list",
+			data:  map[string]interface{}{"certificate": leaf.pem, "issuing_ca": intermediate.pem, "ca_chain": []interface{}{intermediate.pem, root.pem}},
+			chain: []*x509.Certificate{intermediate.cert, root.cert},
+		},
+		{
+			name:  "empty ca chain",
+			data:  map[string]interface{}{"certificate": leaf.pem, "issuing_ca": intermediate.pem, "ca_chain": ""},
+			chain: []*x509.Certificate{intermediate.cert},
+		},
+		{
+			name:  "certificate bundle",
+			data:  map[string]interface{}{"certificate": leaf.pem + intermediate.pem, "issuing_ca": intermediate.pem},
+			chain: []*x509.Certificate{intermediate.cert},
+		},
+		{
+			name: "no certificate",
+			data: map[string]interface{}{"issuing_ca": intermediate.pem},
+			err:  true,
+		},
+		{
+			name: "no ca",
+			data: map[string]interface{}{"certificate": leaf.pem},
+			err:  true,
+		},
+		{
+			name: "bad certificate type",
+			data: map[string]interface{}{"certificate": json.Number("1"), "issuing_ca": intermediate.pem},
+			err:  true,
+		},
+		{
+			name: "bad ca chain element",
+			data: map[string]interface{}{"certificate": leaf.pem, "ca_chain": []interface{}{1}},
+			err:  true,
+		},
+		{
+			name: "bad certificate pem",
+			data: map[string]interface{}{"certificate": "certificate", "issuing_ca": intermediate.pem},
+			err:  true,
+		},
+	}
+
+	for _, c := range cases {
+		response, err := ParseResponse(&api.Secret{Data: c.data})
+
+		if c.err {
+			if _, ok := err.(*FieldError); !ok {
+				t.Errorf("%s: expected field error, got %v", c.name, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+			continue
+		}
+
+		if !response.Certificate.Equal(leaf.cert) {
+			t.Errorf("%s: unexpected certificate %s", c.name, response.Certificate.Subject.CommonName)
+		}
+
+		if len(response.CAChain) != len(c.chain) {
+			t.Errorf("%s: expected %d ca certificates, got %d", c.name, len(c.chain), len(response.CAChain))
+			continue
+		}
+
+		for i := range c.chain {
+			if !response.CAChain[i].Equal(c.chain[i]) {
+				t.Errorf("%s: unexpected ca certificate %d %s", c.name, i, response.CAChain[i].Subject.CommonName)
+			}
+		}
+
+		ca, err := certutil.ParseCertsPEM(response.CAPEM)
+		if err != nil || len(ca) != len(c.chain) {
+			t.Errorf("%s: expected %d certificates in ca pem", c.name, len(c.chain))
+		}
+	}
+
+	if _, err := ParseResponse(nil); err != ErrNoResponse {
+		t.Errorf("expected no response error, got %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ca := newTestCert(t, pkix.Name{CommonName: "ca"}, true, nil)
+	leaf := newTestCert(t, pkix.Name{CommonName: "system:node:k-a-node-s36b", Organization: []string{"system:nodes", "system:bootstrappers"}}, false, ca)
+	other := newTestCert(t, pkix.Name{CommonName: "other"}, false, ca)
+
+	response, err := ParseResponse(&api.Secret{Data: map[string]interface{}{
+		"certificate": leaf.pem,
+		"issuing_ca":  ca.pem,
+		"private_key": string(keyPEM(t, leaf.key)),
+	}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := response.VerifyPrivateKey(response.PrivateKeyPEM); err != nil {
+		t.Errorf("expected private key to match: %s", err)
+	}
+
+	if err := response.VerifyPrivateKey(keyPEM(t, other.key)); err != ErrPublicKeyMismatch {
+		t.Errorf("expected public key mismatch, got %v", err)
+	}
+
+	if err := response.VerifySubject("system:node:k-a-node-s36b", []string{"system:bootstrappers", "system:nodes"}); err != nil {
+		t.Errorf("expected subject to match: %s", err)
+	}
+
+	if err := response.VerifySubject("system:node:k-a-node-s36b", []string{"system:nodes"}); errors.Cause(err) != ErrSubjectMismatch {
+		t.Errorf("expected subject mismatch for organization, got %v", err)
+	}
+
+	if err := response.VerifySubject("system:node:other", leaf.cert.Subject.Organization); errors.Cause(err) != ErrSubjectMismatch {
+		t.Errorf("expected subject mismatch for common name, got %v", err)
+	}
+
+	for _, c := range []struct {
+		name    string
+		key     *ecdsa.PrivateKey
+		subject pkix.Name
+		err     error
+	}{
+		{"matching request", leaf.key, leaf.cert.Subject, nil},
+		{"other key", other.key, leaf.cert.Subject, ErrPublicKeyMismatch},
+		{"other subject", leaf.key, pkix.Name{CommonName: "system:node:other"}, ErrSubjectMismatch},
+	} {
+		csr, err := certutil.MakeCSR(c.key, &c.subject, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := response.VerifyRequest(csr); errors.Cause(err) != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestVerifyServing(t *testing.T) {
+	ca := newTestCert(t, pkix.Name{CommonName: "ca"}, true, nil)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newServingCert := func(usage x509.ExtKeyUsage) *Certificate {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			Subject:      pkix.Name{CommonName: "system:node:k-a-node-s36b"},
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			DNSNames:     []string{"k-a-node-s36b.local", "k-a-node-s36b"},
+			IPAddresses:  []net.IP{net.ParseIP("10.0.0.10")},
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &Certificate{Certificate: cert}
+	}
+
+	serving := newServingCert(x509.ExtKeyUsageServerAuth)
+	client := newServingCert(x509.ExtKeyUsageClientAuth)
+
+	for _, c := range []struct {
+		name     string
+		cert     *Certificate
+		dnsNames []string
+		ips      []net.IP
+		err      error
+	}{
+		{"matching names", serving, []string{"k-a-node-s36b.local"}, []net.IP{net.ParseIP("10.0.0.10")}, nil},
+		{"no names", serving, nil, nil, nil},
+		{"missing dns name", serving, []string{"other.local"}, nil, ErrServingMismatch},
+		{"missing ip address", serving, nil, []net.IP{net.ParseIP("10.0.0.11")}, ErrServingMismatch},
+		{"client certificate", client, []string{"k-a-node-s36b.local"}, nil, ErrServingMismatch},
+	} {
+		if err := c.cert.VerifyServing(c.dnsNames, c.ips); errors.Cause(err) != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+	}
+}
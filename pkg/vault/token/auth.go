@@ -1,22 +1,52 @@
 package token
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/hashicorp/vault/api"
 	"github.com/pkg/errors"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
+// DefaultServiceAccountTokenFile is the path kubernetes mounts the service
+// account token at inside a pod
+const DefaultServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
 // ErrNoAuthInfo is returned when an auth provider returns no authentication
 // info when requested from the vault server.
 var ErrNoAuthInfo = errors.New("no auth info returned")
 
+// ErrNoKubernetesClient is returned when a service account token has to be
+// requested from the kubernetes api but no client has been provided.
+var ErrNoKubernetesClient = errors.New("no kubernetes client provided for token request")
+
+// AuthProviderKubernetes authenticates against Vault using a kubernetes
+// service account token.
+//
+// By default the token is read from TokenFile on every login, so rotated
+// projected tokens are always picked up. If Audiences are set then an audience
+// scoped token is requested for ServiceAccount through the TokenRequest API
+// instead, this requires Client to be set. ServiceAccount and Namespace default
+// to the service account of the pod, read from next to TokenFile.
 type AuthProviderKubernetes struct {
 	Mount     string
 	Role      string
 	TokenFile string
+
+	// TokenRequest API options
+	Audiences       []string
+	ServiceAccount  string
+	Namespace       string
+	TokenExpiration time.Duration
+	Client          kubernetes.Interface
 }
 
 func (p AuthProviderKubernetes) String() string {
@@ -27,10 +57,9 @@ func (p AuthProviderKubernetes) String() string {
 func (p AuthProviderKubernetes) Auth(client *api.Client) error {
 	glog.V(2).Info("authenticating using kubernetes service account")
 
-	glog.V(3).Infof("reading service token file %s", p.TokenFile)
-	token, err := ioutil.ReadFile(p.TokenFile)
+	token, err := p.serviceAccountToken()
 	if err != nil {
-		return errors.Wrap(err, "reading service token file")
+		return err
 	}
 
 	glog.V(3).Infof("attempting kubernetes authentication mount=%s role=%s", p.Mount, p.Role)
@@ -38,7 +67,7 @@ func (p AuthProviderKubernetes) Auth(client *api.Client) error {
 		fmt.Sprintf("auth/%s/login", p.Mount),
 		map[string]interface{}{
 			"role": p.Role,
-			"jwt":  string(token),
+			"jwt":  token,
 		},
 	)
 
@@ -55,26 +84,164 @@ func (p AuthProviderKubernetes) Auth(client *api.Client) error {
 	return nil
 }
 
+func (p AuthProviderKubernetes) tokenFile() string {
+	if p.TokenFile == "" {
+		return DefaultServiceAccountTokenFile
+	}
+
+	return p.TokenFile
+}
+
+func (p AuthProviderKubernetes) serviceAccountToken() (string, error) {
+	if len(p.Audiences) > 0 {
+		return p.requestToken()
+	}
+
+	glog.V(3).Infof("reading service token file %s", p.tokenFile())
+	token, err := ioutil.ReadFile(p.tokenFile())
+	if err != nil {
+		return "", errors.Wrap(err, "reading service token file")
+	}
+
+	return strings.TrimSpace(string(token)), nil
+}
+
+func (p AuthProviderKubernetes) requestToken() (string, error) {
+	if p.Client == nil {
+		return "", ErrNoKubernetesClient
+	}
+
+	namespace := p.Namespace
+	if namespace == "" {
+		file := filepath.Join(filepath.Dir(p.tokenFile()), "namespace")
+
+		glog.V(3).Infof("reading service account namespace file %s", file)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", errors.Wrap(err, "reading service account namespace file")
+		}
+
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	serviceAccount := p.ServiceAccount
+	if serviceAccount == "" {
+		var err error
+		serviceAccount, err = p.tokenFileServiceAccount()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	request := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences: p.Audiences,
+		},
+	}
+
+	if p.TokenExpiration > 0 {
+		seconds := int64(p.TokenExpiration / time.Second)
+		request.Spec.ExpirationSeconds = &seconds
+	}
+
+	glog.V(3).Infof("requesting service account token namespace=%s name=%s audiences=%v", namespace, serviceAccount, p.Audiences)
+	result, err := p.Client.CoreV1().ServiceAccounts(namespace).CreateToken(serviceAccount, request)
+	if err != nil {
+		return "", errors.Wrap(err, "requesting service account token")
+	}
+
+	return result.Status.Token, nil
+}
+
+// tokenFileServiceAccount reads the service account name from the claims of
+// the token file, both the legacy secret tokens and projected tokens are
+// supported. The signature is not checked, the name only picks the account
+// to request a token for.
+func (p AuthProviderKubernetes) tokenFileServiceAccount() (string, error) {
+	data, err := ioutil.ReadFile(p.tokenFile())
+	if err != nil {
+		return "", errors.Wrap(err, "reading service token file for the service account name")
+	}
+
+	parts := strings.Split(strings.TrimSpace(string(data)), ".")
+	if len(parts) != 3 {
+		return "", errors.New("service token file is not a jwt, the service account must be set")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", errors.Wrap(err, "decoding service token claims")
+	}
+
+	var claims struct {
+		Name       string `json:"kubernetes.io/serviceaccount/service-account.name"`
+		Kubernetes struct {
+			ServiceAccount struct {
+				Name string `json:"name"`
+			} `json:"serviceaccount"`
+		} `json:"kubernetes.io"`
+	}
+
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", errors.Wrap(err, "decoding service token claims")
+	}
+
+	if claims.Kubernetes.ServiceAccount.Name != "" {
+		return claims.Kubernetes.ServiceAccount.Name, nil
+	}
+
+	if claims.Name != "" {
+		return claims.Name, nil
+	}
+
+	return "", errors.New("no service account name in the service token, the service account must be set")
+}
+
+// AuthProviderAppRole authenticates against Vault using an approle.
+//
+// The role id and secret id can be read from files, in which case they are
+// re-read on every login. The secret id can also be delivered as a response
+// wrapping token, which is unwrapped using sys/wrapping/unwrap.
 type AuthProviderAppRole struct {
 	Mount    string
 	RoleID   string
 	SecretID string
+
+	RoleIDFile                string
+	SecretIDFile              string
+	SecretIDWrappingToken     string
+	SecretIDWrappingTokenFile string
+
+	// wrapping tokens are single use, so the unwrapped secret id is kept
+	// until a new wrapping token is provided
+	unwrappedToken    string
+	unwrappedSecretID string
 }
 
-func (p AuthProviderAppRole) String() string {
+func (p *AuthProviderAppRole) String() string {
 	return "approle"
 }
 
-// AppRoleAuth authenticates against Vault using an approle and secret.
-func (p AuthProviderAppRole) Auth(client *api.Client) error {
+// Auth implements AuthProvider
+func (p *AuthProviderAppRole) Auth(client *api.Client) error {
 	glog.V(2).Info("authenticating using approle")
 
-	glog.V(3).Infof("attempting approle authentication roleid=%s", p.RoleID)
+	roleID, err := readValue(p.RoleID, p.RoleIDFile)
+	if err != nil {
+		return errors.Wrap(err, "reading approle role id")
+	}
+
+	secretID, err := p.secretID(client)
+	if err != nil {
+		return err
+	}
+
+	glog.V(3).Infof("attempting approle authentication roleid=%s", roleID)
 	secret, err := client.Logical().Write(
 		fmt.Sprintf("auth/%s/login", p.Mount),
 		map[string]interface{}{
-			"role_id":   p.RoleID,
-			"secret_id": p.SecretID,
+			"role_id":   roleID,
+			"secret_id": secretID,
 		},
 	)
 
@@ -90,3 +257,64 @@ func (p AuthProviderAppRole) Auth(client *api.Client) error {
 
 	return nil
 }
+
+func (p *AuthProviderAppRole) secretID(client *api.Client) (string, error) {
+	wrappingToken, err := readValue(p.SecretIDWrappingToken, p.SecretIDWrappingTokenFile)
+	if err != nil {
+		return "", errors.Wrap(err, "reading approle secret id wrapping token")
+	}
+
+	if wrappingToken == "" {
+		secretID, err := readValue(p.SecretID, p.SecretIDFile)
+		return secretID, errors.Wrap(err, "reading approle secret id")
+	}
+
+	if wrappingToken == p.unwrappedToken {
+		return p.unwrappedSecretID, nil
+	}
+
+	// The wrapping token is used as the client token for the unwrap call,
+	// so use a copy of the client to avoid replacing any existing token
+	unwrapClient, err := client.Clone()
+	if err != nil {
+		return "", errors.Wrap(err, "cloning vault client")
+	}
+
+	unwrapClient.SetToken(wrappingToken)
+
+	glog.V(3).Info("unwrapping approle secret id")
+	secret, err := unwrapClient.Logical().Unwrap("")
+	if err != nil {
+		return "", errors.Wrap(err, "unwrapping approle secret id")
+	}
+
+	if secret == nil {
+		return "", errors.New("unwrapping approle secret id: no data returned")
+	}
+
+	secretID, ok := secret.Data["secret_id"].(string)
+	if !ok || secretID == "" {
+		return "", errors.New("unwrapping approle secret id: no secret id in response")
+	}
+
+	p.unwrappedToken = wrappingToken
+	p.unwrappedSecretID = secretID
+
+	return secretID, nil
+}
+
+// readValue returns the trimmed contents of file if one is given, otherwise
+// the value is returned
+func readValue(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+
+	glog.V(3).Infof("reading file %s", file)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,401 @@
+package token
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/metadata"
+)
+
+const (
+	// DefaultAWSMetadataEndpoint is the address of the EC2 instance metadata
+	// service
+	DefaultAWSMetadataEndpoint = metadata.DefaultAWSEndpoint
+
+	// DefaultAWSSTSEndpoint is the global STS endpoint, requests to it are
+	// signed for the us-east-1 region
+	DefaultAWSSTSEndpoint = "https://sts.amazonaws.com"
+
+	awsGetCallerIdentityBody = "Action=GetCallerIdentity&Version=2011-06-15"
+	awsIAMServerIDHeader     = "X-Vault-AWS-IAM-Server-ID"
+	awsTimeFormat            = "20060102T150405Z"
+	awsDateFormat            = "20060102"
+)
+
+// ErrNoAWSCredentials is returned when no AWS credentials could be found in
+// the environment, the shared credentials file or the instance metadata
+var ErrNoAWSCredentials = errors.New("no aws credentials found")
+
+// AuthProviderAWSIAM authenticates against Vault using the AWS IAM auth
+// method. A signed sts:GetCallerIdentity request is built and sent to Vault,
+// which performs it to verify the identity of the caller.
+//
+// Credentials are taken from the first of the following that is available:
+//
+// - The AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+//   environment variables
+// - The shared credentials file, using AWS_SHARED_CREDENTIALS_FILE and
+//   AWS_PROFILE if set
+// - The IAM role of the instance, using the EC2 instance metadata service
+type AuthProviderAWSIAM struct {
+	Mount       string
+	Role        string
+	HeaderValue string
+
+	// STS endpoint and the region requests to it are signed for
+	STSEndpoint string
+	STSRegion   string
+
+	// Credential sources
+	CredentialsFile  string
+	Profile          string
+	MetadataEndpoint string
+}
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+func (p AuthProviderAWSIAM) String() string {
+	return "aws"
+}
+
+// Auth implements AuthProvider
+func (p AuthProviderAWSIAM) Auth(client *api.Client) error {
+	glog.V(2).Info("authenticating using aws iam")
+
+	creds, err := p.credentials()
+	if err != nil {
+		return errors.Wrap(err, "loading aws credentials")
+	}
+
+	data, err := p.loginData(creds, time.Now().UTC())
+	if err != nil {
+		return errors.Wrap(err, "generating aws login data")
+	}
+
+	glog.V(3).Infof("attempting aws iam authentication mount=%s role=%s", p.Mount, p.Role)
+	secret, err := client.Logical().Write(fmt.Sprintf("auth/%s/login", p.Mount), data)
+
+	if err != nil {
+		return errors.Wrap(err, "authenticating with aws iam")
+	}
+
+	if secret.Auth == nil {
+		return ErrNoAuthInfo
+	}
+
+	client.SetToken(secret.Auth.ClientToken)
+
+	return nil
+}
+
+// loginData builds the signed sts:GetCallerIdentity request in the format
+// expected by the Vault AWS auth method
+func (p AuthProviderAWSIAM) loginData(creds *awsCredentials, now time.Time) (map[string]interface{}, error) {
+	endpoint := p.STSEndpoint
+	if endpoint == "" {
+		endpoint = DefaultAWSSTSEndpoint
+	}
+
+	region := p.STSRegion
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	body := []byte(awsGetCallerIdentityBody)
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(endpoint, "/")+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	if p.HeaderValue != "" {
+		req.Header.Set(awsIAMServerIDHeader, p.HeaderValue)
+	}
+
+	signAWSRequest(req, body, creds, region, "sts", now)
+
+	headers, err := json.Marshal(req.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"role":                    p.Role,
+		"iam_http_request_method": req.Method,
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte(req.URL.String())),
+		"iam_request_body":        base64.StdEncoding.EncodeToString(body),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString(headers),
+	}, nil
+}
+
+func (p AuthProviderAWSIAM) credentials() (*awsCredentials, error) {
+	if creds := awsEnvCredentials(); creds != nil {
+		glog.V(3).Info("using aws credentials from environment")
+		return creds, nil
+	}
+
+	creds, err := awsSharedCredentials(p.CredentialsFile, p.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if creds != nil {
+		glog.V(3).Info("using aws credentials from shared credentials file")
+		return creds, nil
+	}
+
+	endpoint := p.MetadataEndpoint
+	if endpoint == "" {
+		endpoint = DefaultAWSMetadataEndpoint
+	}
+
+	glog.V(3).Info("using aws credentials from instance metadata")
+	return awsInstanceCredentials(endpoint)
+}
+
+func awsEnvCredentials() *awsCredentials {
+	creds := &awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil
+	}
+
+	return creds
+}
+
+// awsSharedCredentials reads credentials for a profile from an AWS shared
+// credentials file, nil is returned if the file or profile does not exist
+func awsSharedCredentials(file, profile string) (*awsCredentials, error) {
+	if file == "" {
+		file = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	}
+
+	if file == "" {
+		file = filepath.Join(os.Getenv("HOME"), ".aws", "credentials")
+	}
+
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "opening shared credentials file")
+	}
+
+	defer f.Close()
+
+	var section string
+	creds := &awsCredentials{}
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		case section != profile:
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(parts[1])
+
+		switch strings.TrimSpace(parts[0]) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading shared credentials file")
+	}
+
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, nil
+	}
+
+	return creds, nil
+}
+
+// awsInstanceCredentials loads the credentials of the instance IAM role from
+// the EC2 metadata service. IMDSv2 is used if available, falling back to
+// IMDSv1.
+func awsInstanceCredentials(endpoint string) (*awsCredentials, error) {
+	client := metadata.NewAWS(endpoint)
+
+	get := func(path string) ([]byte, error) {
+		data, err := client.Get(path)
+		if errors.Cause(err) == metadata.ErrNotFound {
+			return nil, ErrNoAWSCredentials
+		}
+
+		return data, err
+	}
+
+	roles, err := get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, errors.Wrap(err, "listing instance roles")
+	}
+
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return nil, ErrNoAWSCredentials
+	}
+
+	data, err := get("/latest/meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading instance role credentials")
+	}
+
+	var resp struct {
+		Code            string
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+	}
+
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, errors.Wrap(err, "parsing instance role credentials")
+	}
+
+	if resp.Code != "" && resp.Code != "Success" {
+		return nil, errors.Errorf("instance role credentials unavailable: %s", resp.Code)
+	}
+
+	return &awsCredentials{
+		AccessKeyID:     resp.AccessKeyID,
+		SecretAccessKey: resp.SecretAccessKey,
+		SessionToken:    resp.Token,
+	}, nil
+}
+
+// signAWSRequest signs a request using AWS signature version 4, setting the
+// X-Amz-Date, X-Amz-Security-Token and Authorization headers
+func signAWSRequest(req *http.Request, body []byte, creds *awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format(awsTimeFormat)
+	date := now.UTC().Format(awsDateFormat)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	// Build the canonical headers, host is not stored in the header map so
+	// it is added separately
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	bodyHash := sha256.Sum256(body)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = awsHMAC(key, part)
+	}
+
+	signature := hex.EncodeToString(awsHMAC(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func awsHMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
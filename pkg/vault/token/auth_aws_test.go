@@ -0,0 +1,127 @@
+package token
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAWSRequest(t *testing.T) {
+	// get-vanilla from the AWS signature version 4 test suite
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now, _ := time.Parse(awsTimeFormat, "20150830T123600Z")
+	creds := &awsCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+
+	signAWSRequest(req, nil, creds, "us-east-1", "service", now)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Errorf("expected authorization header %q but got %q", expected, auth)
+	}
+}
+
+func TestAuthProviderAWSIAM(t *testing.T) {
+	for _, env := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"} {
+		if value, ok := os.LookupEnv(env); ok {
+			defer os.Setenv(env, value)
+			os.Unsetenv(env)
+		}
+	}
+
+	// Fake instance metadata service
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/latest/api/token":
+			w.Write([]byte("imds-token"))
+			return
+		case r.Header.Get("X-aws-ec2-metadata-token") != "imds-token":
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("node-role"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/node-role":
+			json.NewEncoder(w).Encode(map[string]string{
+				"Code":            "Success",
+				"AccessKeyId":     "AKIDEXAMPLE",
+				"SecretAccessKey": "secret",
+				"Token":           "session-token",
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer metadata.Close()
+
+	client, logins, stop := testLoginServer(t, "auth/aws/login", "test-token")
+	defer stop()
+
+	provider := AuthProviderAWSIAM{
+		Mount:            "aws",
+		Role:             "node",
+		HeaderValue:      "vault.example.com",
+		CredentialsFile:  "/nonexistent",
+		MetadataEndpoint: metadata.URL,
+	}
+
+	if err := provider.Auth(client); err != nil {
+		t.Fatalf("error authenticating: %s", err)
+	}
+
+	if len(*logins) != 1 {
+		t.Fatalf("expected one login but got %d", len(*logins))
+	}
+
+	login := (*logins)[0]
+
+	if login["role"] != "node" {
+		t.Errorf("expected role node but got %v", login["role"])
+	}
+
+	decode := func(key string) []byte {
+		data, err := base64.StdEncoding.DecodeString(login[key].(string))
+		if err != nil {
+			t.Fatalf("decoding %s: %s", key, err)
+		}
+		return data
+	}
+
+	if url := string(decode("iam_request_url")); url != DefaultAWSSTSEndpoint+"/" {
+		t.Errorf("unexpected request url %s", url)
+	}
+
+	if body := string(decode("iam_request_body")); body != awsGetCallerIdentityBody {
+		t.Errorf("unexpected request body %s", body)
+	}
+
+	headers := http.Header{}
+	if err := json.Unmarshal(decode("iam_request_headers"), &headers); err != nil {
+		t.Fatalf("decoding headers: %s", err)
+	}
+
+	if headers.Get(awsIAMServerIDHeader) != "vault.example.com" {
+		t.Errorf("expected server id header but got %q", headers.Get(awsIAMServerIDHeader))
+	}
+
+	if headers.Get("X-Amz-Security-Token") != "session-token" {
+		t.Errorf("expected session token header but got %q", headers.Get("X-Amz-Security-Token"))
+	}
+
+	auth := headers.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "x-vault-aws-iam-server-id") {
+		t.Errorf("unexpected authorization header %q", auth)
+	}
+}
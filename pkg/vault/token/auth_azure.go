@@ -0,0 +1,152 @@
+package token
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/metadata"
+)
+
+const (
+	// DefaultAzureMetadataEndpoint is the address of the Azure instance
+	// metadata service
+	DefaultAzureMetadataEndpoint = metadata.DefaultAzureEndpoint
+
+	// DefaultAzureResource is the resource managed identity tokens are
+	// requested for, it must match the resource configured in vault
+	DefaultAzureResource = "https://management.azure.com/"
+)
+
+// AuthProviderAzure authenticates against Vault using the Azure auth method.
+// A managed identity token is obtained from the instance metadata service,
+// along with the subscription, resource group and VM name of the instance
+// unless they are set explicitly.
+type AuthProviderAzure struct {
+	Mount    string
+	Role     string
+	Resource string
+
+	SubscriptionID    string
+	ResourceGroupName string
+	VMName            string
+	VMSSName          string
+
+	MetadataEndpoint string
+}
+
+func (p AuthProviderAzure) String() string {
+	return "azure"
+}
+
+// Auth implements AuthProvider
+func (p AuthProviderAzure) Auth(client *api.Client) error {
+	glog.V(2).Info("authenticating using azure managed identity")
+
+	jwt, err := p.identityToken()
+	if err != nil {
+		return errors.Wrap(err, "requesting azure managed identity token")
+	}
+
+	data := map[string]interface{}{
+		"role":                p.Role,
+		"jwt":                 jwt,
+		"subscription_id":     p.SubscriptionID,
+		"resource_group_name": p.ResourceGroupName,
+		"vm_name":             p.VMName,
+		"vmss_name":           p.VMSSName,
+	}
+
+	if p.SubscriptionID == "" || p.ResourceGroupName == "" || (p.VMName == "" && p.VMSSName == "") {
+		compute, err := p.instanceCompute()
+		if err != nil {
+			return errors.Wrap(err, "reading azure instance metadata")
+		}
+
+		fill := map[string]string{
+			"subscription_id":     compute.SubscriptionID,
+			"resource_group_name": compute.ResourceGroupName,
+		}
+
+		// the vm and scale set name identify the instance together, so
+		// they are only read from the metadata when neither is set
+		if p.VMName == "" && p.VMSSName == "" {
+			fill["vm_name"] = compute.Name
+			fill["vmss_name"] = compute.VMScaleSetName
+		}
+
+		for key, value := range fill {
+			if data[key] == "" {
+				data[key] = value
+			}
+		}
+	}
+
+	glog.V(3).Infof("attempting azure authentication mount=%s role=%s", p.Mount, p.Role)
+	secret, err := client.Logical().Write(fmt.Sprintf("auth/%s/login", p.Mount), data)
+
+	if err != nil {
+		return errors.Wrap(err, "authenticating with azure")
+	}
+
+	if secret.Auth == nil {
+		return ErrNoAuthInfo
+	}
+
+	client.SetToken(secret.Auth.ClientToken)
+
+	return nil
+}
+
+type azureCompute struct {
+	Name              string `json:"name"`
+	ResourceGroupName string `json:"resourceGroupName"`
+	SubscriptionID    string `json:"subscriptionId"`
+	VMScaleSetName    string `json:"vmScaleSetName"`
+}
+
+func (p AuthProviderAzure) identityToken() (string, error) {
+	resource := p.Resource
+	if resource == "" {
+		resource = DefaultAzureResource
+	}
+
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", resource)
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := p.get("/metadata/identity/oauth2/token", query, &resp); err != nil {
+		return "", err
+	}
+
+	if resp.AccessToken == "" {
+		return "", errors.New("no access token returned")
+	}
+
+	return resp.AccessToken, nil
+}
+
+func (p AuthProviderAzure) instanceCompute() (*azureCompute, error) {
+	query := url.Values{}
+	query.Set("api-version", "2017-08-01")
+
+	var resp struct {
+		Compute azureCompute `json:"compute"`
+	}
+
+	if err := p.get("/metadata/instance", query, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Compute, nil
+}
+
+func (p AuthProviderAzure) get(path string, query url.Values, v interface{}) error {
+	return metadata.NewAzure(p.MetadataEndpoint).GetJSON(path+"?"+query.Encode(), v)
+}
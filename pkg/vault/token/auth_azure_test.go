@@ -0,0 +1,90 @@
+package token
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthProviderAzure(t *testing.T) {
+	// Fake Azure instance metadata service
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			http.Error(w, "missing metadata header", http.StatusBadRequest)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/metadata/identity/oauth2/token":
+			if resource := r.URL.Query().Get("resource"); resource != DefaultAzureResource {
+				t.Errorf("expected resource %s but got %q", DefaultAzureResource, resource)
+			}
+
+			json.NewEncoder(w).Encode(map[string]string{
+				"access_token": "azure-jwt",
+			})
+		case "/metadata/instance":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"compute": map[string]string{
+					"name":              "node-1",
+					"resourceGroupName": "nodes",
+					"subscriptionId":    "subscription",
+					"vmScaleSetName":    "nodes",
+				},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer metadata.Close()
+
+	client, logins, stop := testLoginServer(t, "auth/azure/login", "test-token")
+	defer stop()
+
+	cases := []struct {
+		provider AuthProviderAzure
+		vmName   string
+		vmssName string
+	}{
+		// the instance is read from the metadata
+		{AuthProviderAzure{ResourceGroupName: "override"}, "node-1", "nodes"},
+		// a set vm name is not mixed with the scale set from the metadata
+		{AuthProviderAzure{VMName: "custom"}, "custom", ""},
+	}
+
+	for i, c := range cases {
+		provider := c.provider
+		provider.Mount = "azure"
+		provider.Role = "node"
+		provider.MetadataEndpoint = metadata.URL
+
+		if err := provider.Auth(client); err != nil {
+			t.Fatalf("error authenticating: %s", err)
+		}
+
+		if len(*logins) != i+1 {
+			t.Fatalf("expected %d logins but got %d", i+1, len(*logins))
+		}
+
+		resourceGroup := "nodes"
+		if provider.ResourceGroupName != "" {
+			resourceGroup = provider.ResourceGroupName
+		}
+
+		expected := map[string]interface{}{
+			"role":                "node",
+			"jwt":                 "azure-jwt",
+			"subscription_id":     "subscription",
+			"resource_group_name": resourceGroup,
+			"vm_name":             c.vmName,
+			"vmss_name":           c.vmssName,
+		}
+
+		for key, value := range expected {
+			if (*logins)[i][key] != value {
+				t.Errorf("%d: expected %s to be %q but got %q", i, key, value, (*logins)[i][key])
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package token
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// AuthProviderChain tries a list of auth providers in order until one
+// succeeds. This allows a fallback to be configured, for example during a
+// migration between vault auth backends.
+type AuthProviderChain struct {
+	Providers []AuthProvider
+
+	current AuthProvider
+}
+
+func (p *AuthProviderChain) String() string {
+	names := make([]string, len(p.Providers))
+	for i, provider := range p.Providers {
+		names[i] = provider.String()
+	}
+
+	return strings.Join(names, ",")
+}
+
+// External implements ExternalAuthProvider, the chain is external when the
+// provider that last authenticated is
+func (p *AuthProviderChain) External() bool {
+	external, ok := p.current.(ExternalAuthProvider)
+	return ok && external.External()
+}
+
+// Current returns the provider that last authenticated successfully
+func (p *AuthProviderChain) Current() AuthProvider {
+	return p.current
+}
+
+// Auth implements AuthProvider
+func (p *AuthProviderChain) Auth(client *api.Client) error {
+	var failures []string
+
+	for _, provider := range p.Providers {
+		err := provider.Auth(client)
+		if err == nil {
+			p.current = provider
+			return nil
+		}
+
+		glog.Warningf("authenticating using %s failed, trying next provider: %s", provider, err)
+		metricAuthFailures.Add(provider.String(), 1)
+		failures = append(failures, fmt.Sprintf("%s: %s", provider, err))
+	}
+
+	return errors.Errorf("all auth providers failed: %s", strings.Join(failures, "; "))
+}
@@ -0,0 +1,131 @@
+package token
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+type testAuthProvider struct {
+	name  string
+	token string
+	err   error
+}
+
+func (p testAuthProvider) String() string {
+	return p.name
+}
+
+func (p testAuthProvider) Auth(client *api.Client) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	client.SetToken(p.token)
+	return nil
+}
+
+func TestAuthProviderChain(t *testing.T) {
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		t.Fatal("error initializing HTTP client: ", err)
+	}
+
+	chain := &AuthProviderChain{
+		Providers: []AuthProvider{
+			testAuthProvider{name: "kubernetes", err: errors.New("auth method misconfigured")},
+			testAuthProvider{name: "approle", token: "approle-token"},
+			testAuthProvider{name: "aws", token: "aws-token"},
+		},
+	}
+
+	if chain.String() != "kubernetes,approle,aws" {
+		t.Errorf("unexpected chain name %s", chain.String())
+	}
+
+	renewer := NewRenewer(client, chain)
+	if err := renewer.auth(); err != nil {
+		t.Fatalf("error authenticating: %s", err)
+	}
+
+	if client.Token() != "approle-token" {
+		t.Errorf("expected token from approle but got %q", client.Token())
+	}
+
+	if current := chain.Current(); current == nil || current.String() != "approle" {
+		t.Errorf("expected current provider to be approle but got %v", current)
+	}
+
+	if metricAuthProvider.Value() != "approle" {
+		t.Errorf("expected provider metric to be approle but got %q", metricAuthProvider.Value())
+	}
+
+	chain.Providers = chain.Providers[:1]
+	if err := chain.Auth(client); err == nil {
+		t.Error("expected error when all providers fail")
+	}
+}
+
+func TestAuthProviderChainExternal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The token of the agent sink must not be looked up, renewed or revoked,
+	// so point the client at an address nothing is listening on
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = "http://127.0.0.1:1"
+	clientConfig.MaxRetries = 0
+	client, err := api.NewClient(clientConfig)
+
+	if err != nil {
+		t.Fatal("error initializing HTTP client: ", err)
+	}
+
+	sink := AuthProviderToken{File: filepath.Join(dir, "sink")}
+	chain := &AuthProviderChain{
+		Providers: []AuthProvider{
+			testAuthProvider{name: "kubernetes-mixed", err: errors.New("auth method misconfigured")},
+			sink,
+		},
+	}
+
+	if chain.External() {
+		t.Error("expected chain to not be external before authenticating")
+	}
+
+	renewer := NewRenewer(client, chain)
+
+	for _, token := range []string{"first-token", "second-token"} {
+		if err := ioutil.WriteFile(sink.File, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := renewer.RunOnce(); err != nil {
+			t.Fatalf("error running renewer: %s", err)
+		}
+
+		if client.Token() != token {
+			t.Errorf("expected token %q but got %q", token, client.Token())
+		}
+	}
+
+	if !chain.External() {
+		t.Error("expected chain to be external once the token provider authenticated")
+	}
+
+	if err := renewer.RevokeSelf(); err != nil || client.Token() != "second-token" {
+		t.Errorf("expected the external token to not be revoked, got %v", err)
+	}
+
+	// only the first tick tried the failing provider
+	if failures := metricAuthFailures.Get("kubernetes-mixed"); failures == nil || failures.String() != "1" {
+		t.Errorf("expected one failure recorded for the failing provider, got %v", failures)
+	}
+}
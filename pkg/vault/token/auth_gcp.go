@@ -0,0 +1,91 @@
+package token
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/thatsmrtalbot/k8s-vault-csr/pkg/metadata"
+)
+
+// DefaultGCPMetadataEndpoint is the address of the GCE metadata server
+const DefaultGCPMetadataEndpoint = metadata.DefaultGCPEndpoint
+
+// AuthProviderGCP authenticates against Vault using the GCP auth method with
+// the gce role type. An identity JWT for the instance service account is
+// obtained from the GCE metadata server.
+type AuthProviderGCP struct {
+	Mount          string
+	Role           string
+	ServiceAccount string
+
+	// Audience of the identity token, defaults to http://vault/<role>
+	Audience string
+
+	MetadataEndpoint string
+}
+
+func (p AuthProviderGCP) String() string {
+	return "gcp"
+}
+
+// Auth implements AuthProvider
+func (p AuthProviderGCP) Auth(client *api.Client) error {
+	glog.V(2).Info("authenticating using gcp instance identity")
+
+	jwt, err := p.identityToken()
+	if err != nil {
+		return errors.Wrap(err, "requesting gce identity token")
+	}
+
+	glog.V(3).Infof("attempting gcp authentication mount=%s role=%s", p.Mount, p.Role)
+	secret, err := client.Logical().Write(
+		fmt.Sprintf("auth/%s/login", p.Mount),
+		map[string]interface{}{
+			"role": p.Role,
+			"jwt":  jwt,
+		},
+	)
+
+	if err != nil {
+		return errors.Wrap(err, "authenticating with gcp")
+	}
+
+	if secret.Auth == nil {
+		return ErrNoAuthInfo
+	}
+
+	client.SetToken(secret.Auth.ClientToken)
+
+	return nil
+}
+
+func (p AuthProviderGCP) identityToken() (string, error) {
+	serviceAccount := p.ServiceAccount
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+
+	audience := p.Audience
+	if audience == "" {
+		audience = fmt.Sprintf("http://vault/%s", p.Role)
+	}
+
+	query := url.Values{}
+	query.Set("audience", audience)
+	query.Set("format", "full")
+
+	data, err := metadata.NewGCP(p.MetadataEndpoint).Get(fmt.Sprintf(
+		"/computeMetadata/v1/instance/service-accounts/%s/identity?%s",
+		url.PathEscape(serviceAccount), query.Encode(),
+	))
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,58 @@
+package token
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthProviderGCP(t *testing.T) {
+	// Fake GCE metadata server
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing metadata flavor", http.StatusForbidden)
+			return
+		}
+
+		if r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/identity" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if audience := r.URL.Query().Get("audience"); audience != "http://vault/node" {
+			t.Errorf("expected audience http://vault/node but got %q", audience)
+		}
+
+		if format := r.URL.Query().Get("format"); format != "full" {
+			t.Errorf("expected full format but got %q", format)
+		}
+
+		w.Write([]byte("gce-jwt"))
+	}))
+	defer metadata.Close()
+
+	client, logins, stop := testLoginServer(t, "auth/gcp/login", "test-token")
+	defer stop()
+
+	provider := AuthProviderGCP{
+		Mount:            "gcp",
+		Role:             "node",
+		MetadataEndpoint: metadata.URL,
+	}
+
+	if err := provider.Auth(client); err != nil {
+		t.Fatalf("error authenticating: %s", err)
+	}
+
+	if len(*logins) != 1 {
+		t.Fatalf("expected one login but got %d", len(*logins))
+	}
+
+	if login := (*logins)[0]; login["jwt"] != "gce-jwt" || login["role"] != "node" {
+		t.Errorf("unexpected login data %v", login)
+	}
+
+	if client.Token() != "test-token" {
+		t.Errorf("expected client token to be set, got %q", client.Token())
+	}
+}
@@ -0,0 +1,352 @@
+package token
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/builtin/credential/approle"
+	"github.com/hashicorp/vault/helper/logging"
+	vaulthttp "github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/physical/inmem"
+	"github.com/hashicorp/vault/vault"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// testVaultServer starts an in memory vault server with the given credential
+// backends available, the returned client has the root token set
+func testVaultServer(t *testing.T, credentialBackends map[string]logical.Factory) (*api.Client, func()) {
+	logger := logging.NewVaultLogger(log.Trace)
+
+	phys, err := inmem.NewInmem(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, err := vault.NewCore(&vault.CoreConfig{
+		Physical:           phys,
+		CredentialBackends: credentialBackends,
+		DisableMlock:       true,
+	})
+
+	if err != nil {
+		t.Fatal("error initializing core: ", err)
+	}
+
+	init, err := core.Initialize(context.Background(), &vault.InitParams{
+		BarrierConfig: &vault.SealConfig{
+			SecretShares:    1,
+			SecretThreshold: 1,
+		},
+		RecoveryConfig: nil,
+	})
+
+	if err != nil {
+		t.Fatal("error initializing core: ", err)
+	}
+
+	if unsealed, err := core.Unseal(init.SecretShares[0]); err != nil {
+		t.Fatal("error unsealing core: ", err)
+	} else if !unsealed {
+		t.Fatal("vault shouldn't be sealed")
+	}
+
+	ln, addr := vaulthttp.TestServer(nil, core)
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = addr
+	client, err := api.NewClient(clientConfig)
+
+	if err != nil {
+		ln.Close()
+		t.Fatal("error initializing HTTP client: ", err)
+	}
+
+	client.SetToken(init.RootToken)
+
+	return client, func() { ln.Close() }
+}
+
+// testLoginServer starts a fake vault server that records the body of every
+// login request and responds with the given client token
+func testLoginServer(t *testing.T, path, clientToken string) (*api.Client, *[]map[string]interface{}, func()) {
+	var logins []map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/"+path {
+			http.NotFound(w, r)
+			return
+		}
+
+		body := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		logins = append(logins, body)
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token": clientToken,
+			},
+		})
+	}))
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = server.URL
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		server.Close()
+		t.Fatal("error initializing HTTP client: ", err)
+	}
+
+	return client, &logins, server.Close
+}
+
+func TestAuthProviderKubernetesTokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client, logins, stop := testLoginServer(t, "auth/kubernetes/login", "test-token")
+	defer stop()
+
+	provider := AuthProviderKubernetes{
+		Mount:     "kubernetes",
+		Role:      "test",
+		TokenFile: filepath.Join(dir, "token"),
+	}
+
+	// Tokens are rotated by the kubelet, so each login should read the
+	// current contents of the file
+	for _, jwt := range []string{"first-jwt", "second-jwt"} {
+		if err := ioutil.WriteFile(provider.TokenFile, []byte(jwt+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := provider.Auth(client); err != nil {
+			t.Fatalf("error authenticating: %s", err)
+		}
+
+		login := (*logins)[len(*logins)-1]
+		if login["jwt"] != jwt {
+			t.Errorf("expected jwt %q but got %q", jwt, login["jwt"])
+		}
+		if login["role"] != "test" {
+			t.Errorf("expected role test but got %q", login["role"])
+		}
+	}
+
+	if client.Token() != "test-token" {
+		t.Errorf("expected client token to be set, got %q", client.Token())
+	}
+}
+
+func TestAuthProviderKubernetesTokenRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "namespace"), []byte("kube-system"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, logins, stop := testLoginServer(t, "auth/kubernetes/login", "test-token")
+	defer stop()
+
+	var serviceAccount string
+
+	kclient := fake.NewSimpleClientset()
+	kclient.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create := action.(k8stesting.CreateActionImpl)
+		serviceAccount = create.Name
+
+		if create.GetSubresource() != "token" {
+			t.Errorf("expected token subresource but got %q", create.GetSubresource())
+		}
+		if create.GetNamespace() != "kube-system" {
+			t.Errorf("expected namespace kube-system but got %q", create.GetNamespace())
+		}
+
+		request := create.GetObject().(*authenticationv1.TokenRequest)
+		if !reflect.DeepEqual(request.Spec.Audiences, []string{"vault"}) {
+			t.Errorf("expected audiences [vault] but got %v", request.Spec.Audiences)
+		}
+
+		return true, &authenticationv1.TokenRequest{
+			Status: authenticationv1.TokenRequestStatus{
+				Token: "requested-jwt",
+			},
+		}, nil
+	})
+
+	provider := AuthProviderKubernetes{
+		Mount:          "kubernetes",
+		Role:           "test",
+		TokenFile:      filepath.Join(dir, "token"),
+		Audiences:      []string{"vault"},
+		ServiceAccount: "k8s-vault-csr",
+		Client:         kclient,
+	}
+
+	if err := provider.Auth(client); err != nil {
+		t.Fatalf("error authenticating: %s", err)
+	}
+
+	if len(*logins) != 1 || (*logins)[0]["jwt"] != "requested-jwt" {
+		t.Errorf("expected login with requested token, got %v", *logins)
+	}
+	if serviceAccount != "k8s-vault-csr" {
+		t.Errorf("expected service account k8s-vault-csr but got %q", serviceAccount)
+	}
+
+	// Without a service account the name is read from the pod token
+	provider.ServiceAccount = ""
+
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"kubernetes.io":{"namespace":"kube-system","serviceaccount":{"name":"pod-account"}}}`))
+	if err := ioutil.WriteFile(provider.TokenFile, []byte("header."+claims+".signature\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.Auth(client); err != nil {
+		t.Fatalf("error authenticating: %s", err)
+	}
+	if serviceAccount != "pod-account" {
+		t.Errorf("expected service account pod-account but got %q", serviceAccount)
+	}
+
+	if err := ioutil.WriteFile(provider.TokenFile, []byte("not-a-jwt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.Auth(client); err == nil {
+		t.Error("expected an error without a service account")
+	}
+
+	provider.Client = nil
+	if err := provider.Auth(client); err != ErrNoKubernetesClient {
+		t.Errorf("expected ErrNoKubernetesClient but got %v", err)
+	}
+}
+
+func TestAuthProviderAppRole(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client, stop := testVaultServer(t, map[string]logical.Factory{
+		"approle": approle.Factory,
+	})
+	defer stop()
+
+	// Setup approle
+
+	err = client.Sys().EnableAuthWithOptions("approle", &api.EnableAuthOptions{
+		Type: "approle",
+	})
+
+	if err != nil {
+		t.Fatal("error enabling approle: ", err)
+	}
+
+	_, err = client.Logical().Write("auth/approle/role/test", map[string]interface{}{
+		"secret_id_num_uses": 0,
+	})
+
+	if err != nil {
+		t.Fatal("error creating approle: ", err)
+	}
+
+	roleID, err := client.Logical().Read("auth/approle/role/test/role-id")
+	if err != nil {
+		t.Fatal("error reading role id: ", err)
+	}
+
+	secretID, err := client.Logical().Write("auth/approle/role/test/secret-id", nil)
+	if err != nil {
+		t.Fatal("error creating secret id: ", err)
+	}
+
+	wrapClient, err := client.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapClient.SetToken(client.Token())
+	wrapClient.SetWrappingLookupFunc(func(operation, path string) string {
+		return "5m"
+	})
+
+	wrappedSecretID, err := wrapClient.Logical().Write("auth/approle/role/test/secret-id", nil)
+	if err != nil {
+		t.Fatal("error creating wrapped secret id: ", err)
+	}
+
+	roleIDFile := filepath.Join(dir, "role-id")
+	secretIDFile := filepath.Join(dir, "secret-id")
+	wrappingTokenFile := filepath.Join(dir, "wrapping-token")
+
+	files := map[string]string{
+		roleIDFile:        roleID.Data["role_id"].(string),
+		secretIDFile:      secretID.Data["secret_id"].(string),
+		wrappingTokenFile: wrappedSecretID.WrapInfo.Token,
+	}
+
+	for file, data := range files {
+		if err := ioutil.WriteFile(file, []byte(data+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Test cases
+
+	providers := map[string]*AuthProviderAppRole{
+		"files": {
+			Mount:        "approle",
+			RoleIDFile:   roleIDFile,
+			SecretIDFile: secretIDFile,
+		},
+		"wrapped": {
+			Mount:                     "approle",
+			RoleIDFile:                roleIDFile,
+			SecretIDWrappingTokenFile: wrappingTokenFile,
+		},
+	}
+
+	for name, provider := range providers {
+		// Login twice, the second login must not re-read a consumed
+		// wrapping token
+		for i := 0; i < 2; i++ {
+			client.ClearToken()
+
+			if err := provider.Auth(client); err != nil {
+				t.Errorf("%s: error authenticating: %s", name, err)
+				continue
+			}
+
+			if client.Token() == "" {
+				t.Errorf("%s: expected token to be set", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package token
+
+import (
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// ErrNoToken is returned when the token provider has no token to use
+var ErrNoToken = errors.New("no vault token provided")
+
+// AuthProviderToken uses a token owned by another process, such as the
+// token written to a Vault Agent sink file or injected as VAULT_TOKEN.
+//
+// The file is checked on every renewer tick and the client token is updated
+// when it is rewritten. The token is never renewed, that is the
+// responsibility of its owner.
+type AuthProviderToken struct {
+	// File to read the token from, if empty the VAULT_TOKEN environment
+	// variable is used instead
+	File string
+}
+
+func (p AuthProviderToken) String() string {
+	return "token"
+}
+
+// External implements ExternalAuthProvider
+func (p AuthProviderToken) External() bool {
+	return true
+}
+
+// Auth implements AuthProvider
+func (p AuthProviderToken) Auth(client *api.Client) error {
+	token := os.Getenv(api.EnvVaultToken)
+
+	if p.File != "" {
+		var err error
+		if token, err = readValue("", p.File); err != nil {
+			return errors.Wrap(err, "reading token file")
+		}
+	}
+
+	if token == "" {
+		return ErrNoToken
+	}
+
+	if token != client.Token() {
+		glog.Info("vault token changed - updating client")
+		client.SetToken(token)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package token
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// ErrNoPassword is returned when no password is configured and there is no
+// terminal to prompt for one
+var ErrNoPassword = errors.New("no password provided and stdin is not a terminal")
+
+// prompt reads a value from the terminal, the input is hidden if secret is
+// set. It is a variable so tests can replace it.
+var prompt = func(message string, secret bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", ErrNoPassword
+	}
+
+	fmt.Fprint(os.Stderr, message)
+
+	if !secret {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		return strings.TrimSpace(line), err
+	}
+
+	data, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+
+	return string(data), err
+}
+
+// AuthProviderUserpass authenticates against Vault using a username and
+// password, intended for operators running bootstrap by hand.
+//
+// The password is taken from Password, then PasswordFile, and is otherwise
+// prompted for on the terminal. If an MFA passcode is required it can be
+// provided with Passcode, if it is not provided and the login fails due to
+// MFA the passcode is prompted for on the terminal.
+type AuthProviderUserpass struct {
+	Mount        string
+	Username     string
+	Password     string
+	PasswordFile string
+	Passcode     string
+	MFAMethod    string
+}
+
+// AuthProviderLDAP authenticates against Vault using LDAP credentials, it
+// accepts the same options as AuthProviderUserpass
+type AuthProviderLDAP AuthProviderUserpass
+
+func (p AuthProviderUserpass) String() string {
+	return "userpass"
+}
+
+// Auth implements AuthProvider
+func (p AuthProviderUserpass) Auth(client *api.Client) error {
+	return p.login(client, p.String())
+}
+
+func (p AuthProviderLDAP) String() string {
+	return "ldap"
+}
+
+// Auth implements AuthProvider
+func (p AuthProviderLDAP) Auth(client *api.Client) error {
+	return AuthProviderUserpass(p).login(client, p.String())
+}
+
+func (p AuthProviderUserpass) login(client *api.Client, method string) error {
+	glog.V(2).Infof("authenticating using %s", method)
+
+	password, err := readValue(p.Password, p.PasswordFile)
+	if err != nil {
+		return errors.Wrap(err, "reading password file")
+	}
+
+	if password == "" {
+		password, err = prompt(fmt.Sprintf("Vault %s password for %s: ", method, p.Username), true)
+		if err != nil {
+			return errors.Wrap(err, "reading password")
+		}
+	}
+
+	data := map[string]interface{}{
+		"password": password,
+	}
+
+	if p.MFAMethod != "" {
+		data["method"] = p.MFAMethod
+	}
+
+	if p.Passcode != "" {
+		data["passcode"] = p.Passcode
+	}
+
+	path := fmt.Sprintf("auth/%s/login/%s", p.Mount, p.Username)
+
+	glog.V(3).Infof("attempting %s authentication username=%s", method, p.Username)
+	secret, err := client.Logical().Write(path, data)
+
+	if err != nil && p.Passcode == "" && isMFAError(err) {
+		passcode, promptErr := prompt("MFA passcode: ", false)
+		if promptErr != nil {
+			return errors.Wrapf(err, "authenticating with %s", method)
+		}
+
+		data["passcode"] = passcode
+		secret, err = client.Logical().Write(path, data)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "authenticating with %s", method)
+	}
+
+	if secret.Auth == nil {
+		return ErrNoAuthInfo
+	}
+
+	client.SetToken(secret.Auth.ClientToken)
+
+	return nil
+}
+
+func isMFAError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "passcode") || strings.Contains(msg, "mfa")
+}
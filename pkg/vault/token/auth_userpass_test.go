@@ -0,0 +1,97 @@
+package token
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestAuthProviderUserpass(t *testing.T) {
+	client, logins, stop := testLoginServer(t, "auth/userpass/login/operator", "test-token")
+	defer stop()
+
+	defer func(p func(string, bool) (string, error)) { prompt = p }(prompt)
+	prompt = func(message string, secret bool) (string, error) {
+		if !secret {
+			t.Errorf("expected password prompt to be secret")
+		}
+		return "prompted", nil
+	}
+
+	for expected, provider := range map[string]AuthProviderUserpass{
+		"hunter2":  {Mount: "userpass", Username: "operator", Password: "hunter2"},
+		"prompted": {Mount: "userpass", Username: "operator"},
+	} {
+		client.ClearToken()
+
+		if err := provider.Auth(client); err != nil {
+			t.Errorf("%s: error authenticating: %s", expected, err)
+			continue
+		}
+
+		login := (*logins)[len(*logins)-1]
+		if login["password"] != expected {
+			t.Errorf("expected password %q but got %q", expected, login["password"])
+		}
+
+		if _, ok := login["passcode"]; ok {
+			t.Errorf("expected no passcode to be sent")
+		}
+
+		if client.Token() != "test-token" {
+			t.Errorf("%s: expected token to be set", expected)
+		}
+	}
+}
+
+func TestAuthProviderLDAPPasscode(t *testing.T) {
+	// Fake vault that requires an mfa passcode
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/ldap/login/operator" {
+			http.NotFound(w, r)
+			return
+		}
+
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		if body["passcode"] != "123456" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"errors": []string{"missing mfa passcode"},
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token": "ldap-token",
+			},
+		})
+	}))
+	defer server.Close()
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = server.URL
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		t.Fatal("error initializing HTTP client: ", err)
+	}
+
+	defer func(p func(string, bool) (string, error)) { prompt = p }(prompt)
+	prompt = func(message string, secret bool) (string, error) {
+		return "123456", nil
+	}
+
+	provider := AuthProviderLDAP{Mount: "ldap", Username: "operator", Password: "hunter2"}
+	if err := provider.Auth(client); err != nil {
+		t.Fatalf("error authenticating: %s", err)
+	}
+
+	if client.Token() != "ldap-token" {
+		t.Errorf("expected token ldap-token but got %q", client.Token())
+	}
+}
@@ -0,0 +1,158 @@
+package token
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// TokenCache persists a vault token to a file so it can be reused between
+// invocations. The file is only readable by its owner, if a key is set the
+// token is also encrypted at rest using AES-GCM.
+type TokenCache struct {
+	File string
+	Key  []byte
+}
+
+// Load reads the token from the cache, an empty token is returned if the
+// cache file does not exist
+func (c TokenCache) Load() (string, error) {
+	data, err := ioutil.ReadFile(c.File)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", errors.Wrap(err, "reading token cache")
+	}
+
+	if len(c.Key) == 0 {
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	aead, err := c.aead()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "", errors.Wrap(err, "decoding token cache")
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("decrypting token cache: data too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	token, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "decrypting token cache")
+	}
+
+	return string(token), nil
+}
+
+// Save atomically writes the token to the cache
+func (c TokenCache) Save(token string) error {
+	data := []byte(token)
+
+	if len(c.Key) != 0 {
+		aead, err := c.aead()
+		if err != nil {
+			return err
+		}
+
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return errors.Wrap(err, "generating nonce")
+		}
+
+		sealed := aead.Seal(nonce, nonce, data, nil)
+		data = []byte(base64.StdEncoding.EncodeToString(sealed))
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.File), filepath.Base(c.File)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "creating token cache")
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "setting token cache permissions")
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "writing token cache")
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "writing token cache")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), c.File), "writing token cache")
+}
+
+// Restore sets the client token to the cached token if it is still valid and
+// has at least minTTL left, returning whether the cached token is in use
+func (c TokenCache) Restore(client *api.Client, minTTL time.Duration) (bool, error) {
+	token, err := c.Load()
+	if err != nil || token == "" {
+		return false, err
+	}
+
+	previous := client.Token()
+	client.SetToken(token)
+
+	secret, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		glog.V(2).Infof("cached token is not valid: %s", err)
+		client.SetToken(previous)
+		return false, nil
+	}
+
+	ttl, err := secret.Data["ttl"].(json.Number).Int64()
+	if err != nil {
+		client.SetToken(previous)
+		return false, errors.Wrap(err, "parsing cached token ttl")
+	}
+
+	// a ttl of zero means the token does not expire
+	if ttl != 0 && time.Duration(ttl)*time.Second < minTTL {
+		glog.V(2).Infof("cached token ttl %ds is below minimum %s", ttl, minTTL)
+		client.SetToken(previous)
+		return false, nil
+	}
+
+	glog.V(2).Info("using cached vault token")
+
+	return true, nil
+}
+
+func (c TokenCache) aead() (cipher.AEAD, error) {
+	// Derive a fixed size key so any secret can be used
+	key := sha256.Sum256(c.Key)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "creating cipher")
+	}
+
+	return cipher.NewGCM(block)
+}
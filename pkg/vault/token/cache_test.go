@@ -0,0 +1,119 @@
+package token
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestTokenCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, cache := range map[string]TokenCache{
+		"plain":     {File: filepath.Join(dir, "plain")},
+		"encrypted": {File: filepath.Join(dir, "encrypted"), Key: []byte("secret")},
+	} {
+		if token, err := cache.Load(); err != nil || token != "" {
+			t.Errorf("%s: expected empty token from missing cache, got %q %v", name, token, err)
+		}
+
+		if err := cache.Save("cached-token"); err != nil {
+			t.Fatalf("%s: error saving token: %s", name, err)
+		}
+
+		info, err := os.Stat(cache.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%s: expected mode 0600 but got %s", name, info.Mode().Perm())
+		}
+
+		data, _ := ioutil.ReadFile(cache.File)
+		if encrypted := !strings.Contains(string(data), "cached-token"); encrypted != (len(cache.Key) > 0) {
+			t.Errorf("%s: unexpected cache contents %q", name, data)
+		}
+
+		if token, err := cache.Load(); err != nil || token != "cached-token" {
+			t.Errorf("%s: expected cached-token, got %q %v", name, token, err)
+		}
+	}
+
+	wrongKey := TokenCache{File: filepath.Join(dir, "encrypted"), Key: []byte("wrong")}
+	if _, err := wrongKey.Load(); err == nil {
+		t.Error("expected error decrypting with the wrong key")
+	}
+}
+
+func TestTokenCacheRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client, stop := testVaultServer(t, nil)
+	defer stop()
+
+	root := client.Token()
+
+	createToken := func(ttl string) string {
+		client.SetToken(root)
+		secret, err := client.Auth().Token().Create(&api.TokenCreateRequest{TTL: ttl})
+		if err != nil {
+			t.Fatal("error creating child token: ", err)
+		}
+		return secret.Auth.ClientToken
+	}
+
+	revoked := createToken("1h")
+	if err := client.Auth().Token().RevokeOrphan(revoked); err != nil {
+		t.Fatal("error revoking token: ", err)
+	}
+
+	cases := map[string]struct {
+		token    string
+		expected bool
+	}{
+		"valid":   {createToken("1h"), true},
+		"low ttl": {createToken("1m"), false},
+		"revoked": {revoked, false},
+	}
+
+	for name, c := range cases {
+		cache := TokenCache{File: filepath.Join(dir, "cache")}
+		if err := cache.Save(c.token); err != nil {
+			t.Fatal(err)
+		}
+
+		client.SetToken("previous")
+
+		restored, err := cache.Restore(client, 10*time.Minute)
+		if err != nil {
+			t.Errorf("%s: error restoring token: %s", name, err)
+		}
+
+		if restored != c.expected {
+			t.Errorf("%s: expected restored to be %v", name, c.expected)
+		}
+
+		expectedToken := "previous"
+		if c.expected {
+			expectedToken = c.token
+		}
+
+		if client.Token() != expectedToken {
+			t.Errorf("%s: expected client token %q but got %q", name, expectedToken, client.Token())
+		}
+	}
+}
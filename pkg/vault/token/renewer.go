@@ -2,6 +2,8 @@ package token
 
 import (
 	"encoding/json"
+	"expvar"
+	"math"
 	"time"
 
 	"github.com/golang/glog"
@@ -13,6 +15,17 @@ import (
 // an auth method provided
 var ErrNoAuthProvider = errors.New("no vault authentication method provided")
 
+var (
+	// metricAuthProvider is the name of the provider that last authenticated
+	metricAuthProvider = expvar.NewString("vault_auth_provider")
+
+	// metricAuthTotal counts successful authentications by provider
+	metricAuthTotal = expvar.NewMap("vault_auth_total")
+
+	// metricAuthFailures counts failed authentications by provider
+	metricAuthFailures = expvar.NewMap("vault_auth_failures_total")
+)
+
 // NewRenewer creates a Vault token renewer that will renew tokens halfway
 // through their lifespan. If an auth method is provided then the controller
 // can also authenticate against Vault if a authentication method is provided
@@ -35,12 +48,27 @@ func (r *Renewer) currentTokenStatus() (*tokenStatus, error) {
 		return nil, errors.Wrap(err, "looking up own token")
 	}
 
-	expires, err := time.Parse(time.RFC3339, secret.Data["expire_time"].(string))
+	// tokens without an expire time, such as root tokens, never need renewing
+	expireTime, ok := secret.Data["expire_time"].(string)
+	if !ok {
+		return &tokenStatus{
+			HasToken:  true,
+			ExpiresIn: time.Duration(math.MaxInt64),
+		}, nil
+	}
+
+	expires, err := time.Parse(time.RFC3339, expireTime)
 	if err != nil {
 		return nil, errors.Wrap(err, "parsing token expire time")
 	}
 
-	ttl, err := secret.Data["ttl"].(json.Number).Int64()
+	// the lifespan of the token is its creation ttl, ttl is the time remaining
+	ttlField := "creation_ttl"
+	if _, ok := secret.Data[ttlField]; !ok {
+		ttlField = "ttl"
+	}
+
+	ttl, err := secret.Data[ttlField].(json.Number).Int64()
 	if err != nil {
 		return nil, errors.Wrap(err, "parsing token ttl")
 	}
@@ -54,18 +82,35 @@ func (r *Renewer) currentTokenStatus() (*tokenStatus, error) {
 
 	return &tokenStatus{
 		HasToken:  true,
-		ExpiresIn: time.Now().UTC().Sub(expires),
+		ExpiresIn: expires.Sub(time.Now().UTC()),
 		TTL:       time.Duration(ttl) * time.Second,
 	}, nil
 }
 
 func (r *Renewer) auth() error {
-	if r.authProvider != nil {
-		err := r.authProvider.Auth(r.client)
+	if r.authProvider == nil {
+		return ErrNoAuthProvider
+	}
+
+	if err := r.authProvider.Auth(r.client); err != nil {
+		// a chain records the failure of each of its providers
+		if _, ok := r.authProvider.(*AuthProviderChain); !ok {
+			metricAuthFailures.Add(r.authProvider.String(), 1)
+		}
+
 		return errors.Wrap(err, "authenticating with vault")
 	}
 
-	return ErrNoAuthProvider
+	name := r.authProvider.String()
+	if chain, ok := r.authProvider.(*AuthProviderChain); ok {
+		name = chain.Current().String()
+	}
+
+	glog.Infof("authenticated with vault using %s", name)
+	metricAuthProvider.Set(name)
+	metricAuthTotal.Add(name, 1)
+
+	return nil
 }
 
 func (r *Renewer) renew() error {
@@ -74,6 +119,17 @@ func (r *Renewer) renew() error {
 }
 
 func (r *Renewer) tick() error {
+	if p, ok := r.authProvider.(ExternalAuthProvider); ok && p.External() {
+		// only re-read the token of the provider a chain authenticated with
+		provider := AuthProvider(p)
+		if chain, ok := p.(*AuthProviderChain); ok {
+			provider = chain.Current()
+		}
+
+		err := provider.Auth(r.client)
+		return errors.Wrap(err, "reading external token")
+	}
+
 	status, err := r.currentTokenStatus()
 
 	if err != nil {
@@ -98,6 +154,27 @@ func (r *Renewer) tick() error {
 	return nil
 }
 
+// RevokeSelf revokes the current token so it does not outlive the process.
+// Tokens owned by an external auth provider are left alone.
+func (r *Renewer) RevokeSelf() error {
+	if p, ok := r.authProvider.(ExternalAuthProvider); ok && p.External() {
+		return nil
+	}
+
+	if r.client.Token() == "" {
+		return nil
+	}
+
+	glog.Info("revoking vault token")
+	if err := r.client.Auth().Token().RevokeSelf(""); err != nil {
+		return errors.Wrap(err, "revoking token")
+	}
+
+	r.client.ClearToken()
+
+	return nil
+}
+
 // RunOnce runs the renew/auth action once
 func (r *Renewer) RunOnce() error {
 	return r.tick()
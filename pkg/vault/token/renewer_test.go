@@ -2,6 +2,9 @@ package token
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	log "github.com/hashicorp/go-hclog"
@@ -105,4 +108,58 @@ func TestRenewer(t *testing.T) {
 	if err != nil {
 		t.Errorf("error renewing token: %s", err)
 	}
-}
\ No newline at end of file
+
+	err = renewer.RevokeSelf()
+
+	if err != nil {
+		t.Errorf("error revoking token: %s", err)
+	}
+
+	if client.Token() != "" {
+		t.Error("token not cleared after revoke")
+	}
+
+	client.SetToken(secret.Auth.ClientToken)
+	if _, err := client.Auth().Token().LookupSelf(); err == nil {
+		t.Error("token still valid after revoke")
+	}
+}
+
+func TestRenewerExternalToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8s-vault-csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The renewer must not contact vault for external tokens, so point the
+	// client at an address nothing is listening on
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = "http://127.0.0.1:1"
+	clientConfig.MaxRetries = 0
+	client, err := api.NewClient(clientConfig)
+
+	if err != nil {
+		t.Fatal("error initializing HTTP client: ", err)
+	}
+
+	provider := AuthProviderToken{
+		File: filepath.Join(dir, "sink"),
+	}
+
+	renewer := NewRenewer(client, provider)
+
+	for _, token := range []string{"first-token", "second-token"} {
+		if err := ioutil.WriteFile(provider.File, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := renewer.RunOnce(); err != nil {
+			t.Fatalf("error running renewer: %s", err)
+		}
+
+		if client.Token() != token {
+			t.Errorf("expected token %q but got %q", token, client.Token())
+		}
+	}
+}
@@ -20,6 +20,10 @@ type tokenStatus struct {
 // - If the token is half way through its lifespan a token renew is attempted
 // - If the token is expired auth is attempted (requires auth method)
 //
+// If the auth provider is an ExternalAuthProvider the token is owned by
+// another process, so instead of the above auth is called on every tick to
+// pick up any changes to the token.
+//
 // If any of these actions fail the renewer exits with an error, allowing the
 // application to handle to handle this failure. Its worth noting that the
 // vault client has built in support for retrying failed requests, so a single
@@ -35,3 +39,10 @@ type AuthProvider interface {
 	Auth(*api.Client) error
 	String() string
 }
+
+// ExternalAuthProvider is an AuthProvider for tokens owned by another
+// process, such as Vault Agent. The renewer never renews these tokens.
+type ExternalAuthProvider interface {
+	AuthProvider
+	External() bool
+}